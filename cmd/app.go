@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/config"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/metrics"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/misp"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/sentinel"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// app holds what the commands share, it is built from the configuration file.
+type app struct {
+	logger *logrus.Logger
+	conf   config.Config
+	store  *state.Store
+}
+
+// configFlag registers the configuration file flag every command has.
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", "", "The YAML configuration file.")
+}
+
+// loadConfig loads and validates the configuration file.
+func loadConfig(logger *logrus.Logger, confFile string) (config.Config, error) {
+	conf := config.Config{}
+	if err := conf.Load(logger, confFile); err != nil {
+		return conf, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	if err := conf.Validate(); err != nil {
+		return conf, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return conf, nil
+}
+
+// newApp loads the configuration and sets the configured log level.
+func newApp(logger *logrus.Logger, confFile string) (*app, error) {
+	conf, err := loadConfig(logger, confFile)
+	if err != nil {
+		return nil, err
+	}
+
+	logrusLevel, err := logrus.ParseLevel(conf.Log.Level)
+	if err != nil {
+		logger.WithError(err).Error("invalid log level provided")
+		logrusLevel = logrus.InfoLevel
+	}
+	logger.SetLevel(logrusLevel)
+
+	return &app{logger: logger, conf: conf}, nil
+}
+
+// openStore opens the sync state, it is closed by close.
+func (a *app) openStore() (*state.Store, error) {
+	if a.store != nil {
+		return a.store, nil
+	}
+
+	store, err := state.Open(a.conf.State.Path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open sync state: %w", err)
+	}
+
+	// carry the last successful sync over from previous runs, so it does not reset when a run fails
+	if last, err := store.LastSync(); err != nil {
+		a.logger.WithError(err).Warn("could not read last sync time")
+	} else if !last.IsZero() {
+		metrics.LastSuccessfulSync.Set(float64(last.Unix()))
+	}
+
+	a.store = store
+	return store, nil
+}
+
+// stateCutoff returns the time before which pushed indicators have expired in Sentinel, as they are valid for at most expires_months after they were pushed.
+func (a *app) stateCutoff() time.Time {
+	return time.Now().AddDate(0, -a.conf.Sentinel.ExpiresMonths, 0)
+}
+
+// syncSucceeded records a successful sync, and whether it rechecked the pushed indicators, in the sync state and metrics.
+func (a *app) syncSucceeded(rechecked bool) {
+	now := time.Now()
+	metrics.LastSuccessfulSync.Set(float64(now.Unix()))
+
+	if err := a.store.SetLastSync(now); err != nil {
+		a.logger.WithError(err).Error("could not save last sync time")
+	}
+
+	if !rechecked {
+		return
+	}
+
+	if err := a.store.SetLastRecheck(now); err != nil {
+		a.logger.WithError(err).Error("could not save last recheck time")
+	}
+}
+
+// close writes the metrics textfile, if configured, and closes the sync state.
+func (a *app) close() {
+	if a.conf.Metrics.Textfile != "" {
+		if err := metrics.WriteTextfile(a.conf.Metrics.Textfile); err != nil {
+			a.logger.WithError(err).Error("could not write metrics")
+		}
+	}
+
+	if a.store == nil {
+		return
+	}
+
+	if err := a.store.Close(); err != nil {
+		a.logger.WithError(err).Error("could not close sync state")
+	}
+}
+
+// newSource creates the MISP indicator source.
+func (a *app) newSource() (*misp.Source, error) {
+	mispClient, err := misp.New(a.logger, a.conf.MISP.BaseURL, a.conf.MISP.AccessKey, misp.Options{
+		RequestTimeout: a.conf.MISP.RequestTimeout,
+		Retry: misp.RetryPolicy{
+			MaxRetries: a.conf.MISP.MaxRetries,
+			BaseDelay:  a.conf.MISP.RetryBaseDelay,
+			MaxDelay:   a.conf.MISP.RetryMaxDelay,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create MISP client: %w", err)
+	}
+
+	return misp.NewSource(mispClient, misp.SourceConfig{
+		DaysToFetch:    a.conf.MISP.DaysToFetch,
+		TypesToFetch:   a.conf.MISP.TypesToFetch,
+		ObjectsToFetch: a.conf.MISP.ObjectsToFetch,
+		SkipObjects:    a.conf.MISP.SkipObjects,
+		MaxTLP:         a.conf.MISP.MaxTLP,
+		FetchTimeout:   a.conf.MISP.FetchTimeout,
+	}), nil
+}
+
+// cleanupSources returns the sources whose expired indicators are cleaned up.
+func (a *app) cleanupSources(source *misp.Source) []string {
+	if len(a.conf.Sentinel.CleanupSources) > 0 {
+		return a.conf.Sentinel.CleanupSources
+	}
+
+	return []string{source.Name()}
+}
+
+// newSentinel creates the Sentinel client, store may be nil for commands that do not track state.
+func (a *app) newSentinel(store *state.Store) (*sentinel.Sentinel, error) {
+	conf := a.conf
+
+	sen, err := sentinel.New(sentinel.Credentials{
+		AuthMethod:          conf.Sentinel.AuthMethod,
+		TenantID:            conf.Sentinel.TenantID,
+		ClientID:            conf.Sentinel.AppID,
+		ClientSecret:        conf.Sentinel.SecretKey,
+		CertificatePath:     conf.Sentinel.CertificatePath,
+		CertificatePassword: conf.Sentinel.CertificatePassword,
+		FederatedTokenFile:  conf.Sentinel.FederatedTokenFile,
+		SubscriptionID:      conf.Sentinel.SubscriptionID,
+		ResourceGroup:       conf.Sentinel.ResourceGroup,
+		WorkspaceName:       conf.Sentinel.WorkspaceName,
+		WorkspaceID:         conf.Sentinel.WorkspaceID,
+		Cloud:               conf.Sentinel.Cloud,
+		AuthorityHost:       conf.Sentinel.AuthorityHost,
+		ARMEndpoint:         conf.Sentinel.ARMEndpoint,
+		UploadEndpoint:      conf.Sentinel.UploadEndpoint,
+	}, store, sentinel.Options{
+		ExpireMonths:         uint16(conf.Sentinel.ExpiresMonths),
+		UploadAPI:            conf.Sentinel.SubmitAPI == config.SubmitAPIUpload,
+		DeleteRevoked:        conf.Sentinel.RevokeAction == config.RevokeActionDelete,
+		Workers:              conf.Sentinel.Workers,
+		MaxRequestsPerSecond: conf.Sentinel.MaxRequestsPerSecond,
+		Confidence: sentinel.ConfidencePolicy{
+			Default:         conf.Confidence.Default,
+			DecayWeight:     conf.Confidence.DecayWeight,
+			SightingWeight:  conf.Confidence.SightingWeight,
+			TagWeight:       conf.Confidence.TagWeight,
+			SightingsForMax: conf.Confidence.SightingsForMax,
+		},
+		LinkBaseURL: conf.MISP.LinkBaseURL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create sentinel instance: %w", err)
+	}
+
+	return sen, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"os"
+)
+
+// cleanupCommand deletes the expired indicators of our sources, so it can be scheduled apart from sync.
+func cleanupCommand(logger *logrus.Logger, args []string) int {
+	fs := newFlagSet("cleanup")
+	confFile := configFlag(fs)
+	dryRun := fs.Bool("dry-run", false, "Show which indicators cleanup would delete, without deleting anything.")
+	planFile := fs.String("plan-file", "", "Save the plan of a dry run as JSON, to make its changes later with apply.")
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	a, err := newApp(logger, *confFile)
+	if err != nil {
+		logger.WithError(err).WithField("config", *confFile).Error("could not start")
+		return exitFailure
+	}
+	defer a.close()
+
+	source, err := a.newSource()
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	// cleanup only deletes expired indicators, it does not need the sync state
+	sen, err := a.newSentinel(nil)
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	sources := a.cleanupSources(source)
+	logger.WithField("sources", sources).WithField("dry_run", *dryRun).Info("cleaning up Sentinel TI")
+
+	if *dryRun {
+		planner := sen.NewPlanner()
+
+		if err := planner.CleanupThreatIntel(ctx, logger, sources); err != nil {
+			logger.WithError(err).Error("could not plan cleanup of threat intel")
+			return exitFailure
+		}
+
+		if err := writePlan(logger, os.Stdout, planner.Plan(), *planFile); err != nil {
+			logger.WithError(err).Error("could not write plan")
+			return exitFailure
+		}
+
+		return exitOK
+	}
+
+	if err := sen.CleanupThreatIntel(ctx, logger, sources); err != nil {
+		logger.WithError(err).Error("could not clean up threat intel")
+		return exitFailure
+	}
+
+	logger.Info("cleaned up Sentinel TI")
+	return exitOK
+}
@@ -4,121 +4,133 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"github.com/hazcod/crowdstrike2sentinel/config"
-	"github.com/hazcod/crowdstrike2sentinel/pkg/misp"
-	"github.com/hazcod/crowdstrike2sentinel/pkg/sentinel"
 	"github.com/sirupsen/logrus"
-	"net/url"
-	"sync"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
+const (
+	exitOK      = 0
+	exitFailure = 1
+	// exitUsage is returned for unknown commands and invalid flags
+	exitUsage = 2
+	// exitDrift is returned by reconcile when differences were found and not fixed
+	exitDrift = 3
+
+	// defaultCommand runs when no command is given, so existing cron jobs keep syncing
+	defaultCommand = "sync"
+)
+
+type command struct {
+	name        string
+	description string
+	run         func(logger *logrus.Logger, args []string) int
+}
+
+var commands = []command{
+	{name: "sync", description: "Push the MISP changes into Sentinel and revoke retracted indicators.", run: syncCommand},
+	{name: "serve", description: "Keep running and sync, clean up and reconcile on their own schedules.", run: serveCommand},
+	{name: "plan", description: "Show what sync would change in Sentinel, without changing anything.", run: planCommand},
+	{name: "apply", description: "Make exactly the changes of a saved plan.", run: applyCommand},
+	{name: "cleanup", description: "Delete the expired Sentinel indicators of our sources.", run: cleanupCommand},
+	{name: "reconcile", description: "Compare the Sentinel indicators against MISP and optionally fix the differences.", run: reconcileCommand},
+	{name: "inventory", description: "List the Sentinel indicators of our source.", run: inventoryCommand},
+	{name: "create-table", description: "Create the vulnerabilities table in the Log Analytics workspace.", run: createTableCommand},
+	{name: "validate-config", description: "Validate the configuration file without connecting to MISP or Sentinel.", run: validateConfigCommand},
+	{name: "state", description: "Print which MISP attributes were pushed to Sentinel.", run: stateCommand},
+	{name: "markings", description: "Print the STIX marking definitions of the PAP markings on the indicators.", run: markingsCommand},
+}
+
 func main() {
 	logger := logrus.New()
 	logger.SetLevel(logrus.InfoLevel)
-	ctx := context.Background()
 
-	confFile := flag.String("config", "", "The YAML configuration file.")
-	flag.Parse()
+	name, args := splitCommand(os.Args[1:])
 
-	conf := config.Config{}
-	if err := conf.Load(logger, *confFile); err != nil {
-		logger.WithError(err).WithField("config", *confFile).Fatal("failed to load configuration")
+	if name == "help" {
+		printUsage()
+		os.Exit(exitOK)
 	}
 
-	if err := conf.Validate(); err != nil {
-		logger.WithError(err).WithField("config", *confFile).Fatal("invalid configuration")
+	if cmd := findCommand(name); cmd != nil {
+		os.Exit(cmd.run(logger, args))
 	}
 
-	logrusLevel, err := logrus.ParseLevel(conf.Log.Level)
-	if err != nil {
-		logger.WithError(err).Error("invalid log level provided")
-		logrusLevel = logrus.InfoLevel
-	}
-	logger.SetLevel(logrusLevel)
+	fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n", name)
+	printUsage()
+	os.Exit(exitUsage)
+}
 
-	// create misp client
+// splitCommand returns the command and the flags for it, the command may come before or after the flags.
+// Without a command the first argument is taken as one, unless it is a flag and sync runs.
+func splitCommand(args []string) (string, []string) {
+	for i, arg := range args {
+		if arg == "help" || findCommand(arg) != nil {
+			return arg, append(append([]string{}, args[:i]...), args[i+1:]...)
+		}
+	}
 
-	logger.Info("fetching MISP TI indicators")
-	mispClient, err := misp.New(logger, conf.MISP.BaseURL, conf.MISP.AccessKey)
-	if err != nil {
-		logger.WithError(err).Fatal("could not create MISP client")
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
 	}
 
-	mispHostname := conf.MISP.BaseURL
-	mispURL, err := url.Parse(mispHostname)
-	if err != nil {
-		mispHostname = conf.MISP.BaseURL
-		logger.WithError(err).WithField("misp_url", conf.MISP.BaseURL).Error("could not parse MISP URL")
-	} else {
-		mispHostname = mispURL.Hostname()
+	return defaultCommand, args
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
 	}
 
-	// create ms sentinel client
-
-	sen, err := sentinel.New(sentinel.Credentials{
-		TenantID:       conf.Sentinel.TenantID,
-		ClientID:       conf.Sentinel.AppID,
-		ClientSecret:   conf.Sentinel.SecretKey,
-		SubscriptionID: conf.Sentinel.SubscriptionID,
-		ResourceGroup:  conf.Sentinel.ResourceGroup,
-		WorkspaceName:  conf.Sentinel.WorkspaceName,
-	})
-	if err != nil {
-		logger.WithError(err).Fatal("could not create sentinel instance")
+	return nil
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.description)
 	}
 
-	// ---
-
-	taskWg := sync.WaitGroup{}
-	errorChann := make(chan error)
-	/*
-		taskWg.Add(1)
-		go func() {
-			logger.Info("cleaning up Sentinel TI")
-
-			if err := sen.CleanupThreatIntel(ctx, logger); err != nil {
-				errorChann <- fmt.Errorf("could not clean up threat intel: %w", err)
-			}
-
-			taskWg.Done()
-		}()
-	*/
-	// fetch TI indicator from MISP
-
-	taskWg.Add(1)
-	go func() {
-		logger.Info("fetching indicators from MISP")
-		indicators, err := mispClient.FetchIndicators(conf.MISP.DaysToFetch, conf.MISP.TypesToFetch)
-		if err != nil {
-			errorChann <- fmt.Errorf("could not fetch MISP TI indicators: %w", err)
-		}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
 
-		// submit threat intelligence to ms sentinel
+// commandContext is cancelled on SIGTERM or SIGINT, so a command stops cleanly mid-run.
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+}
 
-		logger.WithField("total", len(indicators)).Info("submitting MISP indicators to MS Sentinel")
-		if err := sen.SubmitThreatIntel(ctx, logger, uint16(conf.Sentinel.ExpiresMonths), mispHostname, indicators); err != nil {
-			errorChann <- fmt.Errorf("failed to submit indicators: %w", err)
+// newFlagSet creates the flags of a command, parse errors are returned instead of exiting.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n", os.Args[0], name)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// parseFlags parses the flags of a command and returns the exit code to stop with, if any.
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK, false
 		}
 
-		taskWg.Done()
-	}()
-
-	// wait for work to finish
-	doneChan := make(chan struct{})
-	go func() {
-		taskWg.Wait()
-		close(doneChan)
-	}()
-
-	logger.Info("waiting for tasks to finish")
-	select {
-	case err := <-errorChann:
-		logger.WithError(err).Fatal("failed")
-	case <-doneChan:
-		logger.Info("finished tasks")
+		return exitUsage, false
 	}
 
-	// program finish
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		return exitUsage, false
+	}
 
-	logger.Info("submitted all TI to Sentinel")
+	return exitOK, true
 }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+)
+
+// validateConfigCommand loads and validates the configuration, without connecting to MISP or Sentinel.
+func validateConfigCommand(logger *logrus.Logger, args []string) int {
+	fs := newFlagSet("validate-config")
+	confFile := configFlag(fs)
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if _, err := loadConfig(logger, *confFile); err != nil {
+		logger.WithError(err).WithField("config", *confFile).Error("configuration is invalid")
+		return exitFailure
+	}
+
+	fmt.Fprintln(os.Stdout, "configuration is valid")
+	return exitOK
+}
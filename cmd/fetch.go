@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// syncWindow returns the high-water mark to fetch changes from, or zero to fetch the full days_to_fetch window.
+func syncWindow(logger *logrus.Logger, store *state.Store, backfill bool) (time.Time, error) {
+	if backfill {
+		logger.Info("backfilling, fetching the full source window")
+		return time.Time{}, nil
+	}
+
+	mark, err := store.HighWaterMark()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if mark.IsZero() {
+		logger.Info("no previous sync found, fetching the full source window")
+	}
+
+	return mark, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/sentinel"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// inventoryCommand lists the Sentinel indicators of a source.
+func inventoryCommand(logger *logrus.Logger, args []string) int {
+	fs := newFlagSet("inventory")
+	confFile := configFlag(fs)
+	sourceName := fs.String("source", "", "The source to list the indicators of, defaults to the MISP hostname.")
+	includeRevoked := fs.Bool("include-revoked", false, "Also list revoked indicators.")
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	a, err := newApp(logger, *confFile)
+	if err != nil {
+		logger.WithError(err).WithField("config", *confFile).Error("could not start")
+		return exitFailure
+	}
+	defer a.close()
+
+	if *sourceName == "" {
+		source, err := a.newSource()
+		if err != nil {
+			logger.WithError(err).Error("could not start")
+			return exitFailure
+		}
+
+		*sourceName = source.Name()
+	}
+
+	sen, err := a.newSentinel(nil)
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	if err := printInventory(ctx, os.Stdout, sen, *sourceName, *includeRevoked); err != nil {
+		logger.WithError(err).WithField("source", *sourceName).Error("could not list Sentinel TI")
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+// printInventory writes the Sentinel indicators of the source as a table.
+func printInventory(ctx context.Context, w io.Writer, sen *sentinel.Sentinel, source string, includeRevoked bool) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "INDICATOR\tEXTERNAL ID\tDISPLAY NAME\tVALID UNTIL\tREVOKED")
+
+	if err := sen.Inventory(ctx, source, includeRevoked, func(item sentinel.InventoryIndicator) error {
+		_, err := fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%t\n",
+			item.Name, item.ExternalID, item.DisplayName, item.ValidUntil, item.Revoked)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return table.Flush()
+}
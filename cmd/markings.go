@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/hazcod/crowdstrike2sentinel/pkg/sentinel"
+	"github.com/sirupsen/logrus"
+	"os"
+)
+
+// markingsCommand prints the STIX marking definitions of the PAP markings that are set on the indicators.
+func markingsCommand(logger *logrus.Logger, args []string) int {
+	fs := newFlagSet("markings")
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	bundle, err := sentinel.PAPMarkingDefinitions()
+	if err != nil {
+		logger.WithError(err).Error("could not print marking definitions")
+		return exitFailure
+	}
+
+	if _, err := os.Stdout.Write(append(bundle, '\n')); err != nil {
+		logger.WithError(err).Error("could not print marking definitions")
+		return exitFailure
+	}
+
+	return exitOK
+}
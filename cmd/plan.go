@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/sentinel"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// writePlan prints the changes of a dry run with a summary, and saves the plan when a plan file is given.
+func writePlan(logger *logrus.Logger, w io.Writer, plan *sentinel.Plan, planFile string) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "ACTION\tUUID\tINDICATOR\tDISPLAY NAME\tDETAILS")
+
+	for _, change := range plan.Changes {
+		details := change.Reason
+		if len(change.Changed) > 0 {
+			details = strings.Join(change.Changed, ",")
+		}
+
+		if _, err := fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n",
+			change.Action, change.UUID, change.IndicatorName, change.DisplayName, details); err != nil {
+			return err
+		}
+	}
+
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	summary := plan.Summary()
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to revoke, %d to delete, %d unchanged.\n",
+		summary[sentinel.PlanCreate], summary[sentinel.PlanUpdate], summary[sentinel.PlanRevoke],
+		summary[sentinel.PlanDelete], summary[sentinel.PlanUnchanged])
+
+	if planFile == "" {
+		return nil
+	}
+
+	if err := plan.Save(planFile); err != nil {
+		return err
+	}
+
+	logger.WithField("plan_file", planFile).Info("saved plan, run apply to make these changes")
+	return nil
+}
+
+// applyCommand makes exactly the changes of a plan that was saved by plan or a dry run.
+func applyCommand(logger *logrus.Logger, args []string) int {
+	fs := newFlagSet("apply")
+	confFile := configFlag(fs)
+	planFile := fs.String("plan-file", "", "The plan to apply, as saved by plan.")
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if *planFile == "" {
+		fmt.Fprintln(fs.Output(), "apply requires a -plan-file")
+		fs.Usage()
+		return exitUsage
+	}
+
+	a, err := newApp(logger, *confFile)
+	if err != nil {
+		logger.WithError(err).WithField("config", *confFile).Error("could not start")
+		return exitFailure
+	}
+	defer a.close()
+
+	store, err := a.openStore()
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	sen, err := a.newSentinel(store)
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	if err := runApply(ctx, logger, store, sen, *planFile); err != nil {
+		logger.WithError(err).WithField("plan_file", *planFile).Error("could not apply plan")
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+// runApply makes the changes of a saved plan and moves the high-water mark to where the planned sync ended,
+// held back to before the earliest indicator the upload API rejected.
+func runApply(ctx context.Context, logger *logrus.Logger, store *state.Store, sen *sentinel.Sentinel, planFile string) error {
+	plan, err := sentinel.LoadPlan(planFile)
+	if err != nil {
+		return err
+	}
+
+	highWaterMark := plan.HighWaterMark
+
+	if err := sen.ApplyPlan(ctx, logger, plan); err != nil {
+		var rejected *indicator.RejectedError
+		if !errors.As(err, &rejected) {
+			return fmt.Errorf("could not apply plan: %w", err)
+		}
+
+		logger.WithField("rejected", rejected.Rejected).WithField("earliest", rejected.Earliest.Format(time.RFC3339)).
+			Warn("indicators were rejected, holding back the high-water mark to fetch them again")
+
+		if held := rejected.Earliest.Add(-time.Second); held.Before(highWaterMark) {
+			highWaterMark = held
+		}
+	}
+
+	if highWaterMark.IsZero() {
+		return nil
+	}
+
+	mark, err := store.HighWaterMark()
+	if err != nil {
+		return err
+	}
+
+	if highWaterMark.After(mark) {
+		return store.SetHighWaterMark(highWaterMark)
+	}
+
+	return nil
+}
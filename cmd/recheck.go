@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// recheckPushed fetches every pushed indicator from the source again in a single pass. New sightings and decay change
+// the confidence without changing the modification time that incremental syncs fetch by, and indicators that were deleted
+// or de-IDS'd since they were pushed are retracted in the sink.
+func recheckPushed(ctx context.Context, logger *logrus.Logger, store *state.Store, source indicator.Source, sink indicator.Sink, cutoff time.Time) error {
+	refs, err := pushedRefs(store, cutoff)
+	if err != nil {
+		return err
+	}
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	logger.WithField("indicators", len(refs)).WithField("source", source.Name()).
+		Info("rechecking pushed indicators for retractions and confidence")
+
+	if err := indicator.Recheck(ctx, logger, source, sink, refs); err != nil {
+		return fmt.Errorf("could not recheck pushed indicators: %w", err)
+	}
+
+	return nil
+}
+
+// recheckDue returns whether the recheck interval passed since the pushed indicators were last rechecked.
+func (a *app) recheckDue() bool {
+	last, err := a.store.LastRecheck()
+	if err != nil {
+		a.logger.WithError(err).Warn("could not read last recheck time, rechecking now")
+		return true
+	}
+
+	return time.Since(last) >= a.conf.MISP.RecheckInterval
+}
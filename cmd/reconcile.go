@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/sentinel"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reconcileCommand compares the Sentinel indicators against MISP, it exits with exitDrift when differences were left unfixed.
+func reconcileCommand(logger *logrus.Logger, args []string) int {
+	fs := newFlagSet("reconcile")
+	confFile := configFlag(fs)
+	fix := fs.Bool("fix", false, "Create missing, update drifted and revoke stale indicators.")
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	a, err := newApp(logger, *confFile)
+	if err != nil {
+		logger.WithError(err).WithField("config", *confFile).Error("could not start")
+		return exitFailure
+	}
+	defer a.close()
+
+	store, err := a.openStore()
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	source, err := a.newSource()
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	sen, err := a.newSentinel(store)
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	drift, err := runReconcile(ctx, logger, source, sen, *fix)
+	if err != nil {
+		logger.WithError(err).Error("could not reconcile")
+		return exitFailure
+	}
+
+	if drift && !*fix {
+		return exitDrift
+	}
+
+	return exitOK
+}
+
+// runReconcile diffs the source indicators against the Sentinel inventory of that source, and fixes the drift if asked.
+// It returns whether any differences were found.
+func runReconcile(ctx context.Context, logger *logrus.Logger, source indicator.Source, sen *sentinel.Sentinel, fix bool) (bool, error) {
+	// always compare against the full window, not only the latest changes
+	indicators, err := indicator.Collect(ctx, source, time.Time{})
+	if err != nil {
+		return false, err
+	}
+
+	report, err := sen.Reconcile(ctx, logger, source, indicators, fix)
+	if err != nil {
+		return false, fmt.Errorf("could not reconcile Sentinel TI: %w", err)
+	}
+
+	for _, uuid := range report.Missing {
+		logger.WithField("uuid", uuid).Info("missing in Sentinel")
+	}
+
+	for _, name := range report.Stale {
+		logger.WithField("name", name).Info("stale in Sentinel")
+	}
+
+	for _, name := range report.Unverified {
+		logger.WithField("name", name).Debug("not in the fetch window and without sync state, cannot check for retraction")
+	}
+
+	drifted := make([]string, 0, len(report.Drifted))
+	for name := range report.Drifted {
+		drifted = append(drifted, name)
+	}
+	sort.Strings(drifted)
+
+	for _, name := range drifted {
+		logger.WithField("name", name).WithField("changed", strings.Join(report.Drifted[name], ",")).
+			Info("drifted in Sentinel")
+	}
+
+	logger.WithField("missing", len(report.Missing)).WithField("stale", len(report.Stale)).
+		WithField("drifted", len(report.Drifted)).WithField("unverified", len(report.Unverified)).WithField("fixed", fix).
+		Info("reconciled Sentinel TI inventory")
+
+	return len(report.Missing)+len(report.Stale)+len(report.Drifted) > 0, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// pushedRefs returns the previously pushed indicators that are neither revoked nor expired, those last pushed before cutoff have expired in the sink.
+func pushedRefs(store *state.Store, cutoff time.Time) ([]indicator.Ref, error) {
+	refs := make([]indicator.Ref, 0)
+
+	if err := store.Walk(func(record state.Record) error {
+		if !record.Revoked && !record.LastPushed.Before(cutoff) {
+			refs = append(refs, indicator.Ref{UUID: record.AttributeUUID, Composite: record.Object})
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("could not read sync state: %w", err)
+	}
+
+	return refs, nil
+}
+
+// pruneState forgets the pushed indicators that have expired in the sink since, so later syncs do not check them anymore.
+func pruneState(logger *logrus.Logger, store *state.Store, cutoff time.Time) error {
+	pruned, err := store.Prune(cutoff)
+	if err != nil {
+		return err
+	}
+
+	if pruned > 0 {
+		logger.WithField("pruned", pruned).WithField("before", cutoff.Format(time.RFC3339)).
+			Info("forgot expired indicators in the sync state")
+	}
+
+	return nil
+}
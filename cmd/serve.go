@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"github.com/hazcod/crowdstrike2sentinel/config"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/metrics"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/scheduler"
+	"github.com/sirupsen/logrus"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serveCommand keeps the MISP and Sentinel clients alive and runs sync, cleanup and reconcile on their own schedules.
+func serveCommand(logger *logrus.Logger, args []string) int {
+	fs := newFlagSet("serve")
+	confFile := configFlag(fs)
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	a, err := newApp(logger, *confFile)
+	if err != nil {
+		logger.WithError(err).WithField("config", *confFile).Error("could not start")
+		return exitFailure
+	}
+	defer a.close()
+
+	store, err := a.openStore()
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	source, err := a.newSource()
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	sen, err := a.newSentinel(store)
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	conf := a.conf.Serve
+	jobs := make([]scheduler.Job, 0, 3)
+
+	// jobs that change Sentinel or the sync state are exclusive, so they never run at the same time
+	addJob := func(name string, schedule config.Schedule, exclusive bool, run func(ctx context.Context) error) error {
+		parsed, err := scheduler.Parse(schedule.Interval, schedule.Cron)
+		if err != nil {
+			return err
+		}
+
+		jobs = append(jobs, scheduler.Job{Name: name, Schedule: parsed, Jitter: schedule.Jitter, Exclusive: exclusive, Run: run})
+		return nil
+	}
+
+	if err := addJob("sync", conf.Sync, true, func(ctx context.Context) error {
+		cutoff := a.stateCutoff()
+		if err := pruneState(logger, store, cutoff); err != nil {
+			return err
+		}
+
+		recheck := a.recheckDue()
+		if err := runSync(ctx, logger, store, source, sen, store.SetHighWaterMark, cutoff, false, recheck); err != nil {
+			return err
+		}
+
+		a.syncSucceeded(recheck)
+		return nil
+	}); err != nil {
+		logger.WithError(err).Error("invalid sync schedule")
+		return exitFailure
+	}
+
+	if !a.conf.Sentinel.SkipDelete {
+		if err := addJob("cleanup", conf.Cleanup, true, func(ctx context.Context) error {
+			return sen.CleanupThreatIntel(ctx, logger, a.cleanupSources(source))
+		}); err != nil {
+			logger.WithError(err).Error("invalid cleanup schedule")
+			return exitFailure
+		}
+	}
+
+	if conf.Reconcile.Enabled() {
+		if err := addJob("reconcile", conf.Reconcile, conf.ReconcileFix, func(ctx context.Context) error {
+			_, err := runReconcile(ctx, logger, source, sen, conf.ReconcileFix)
+			return err
+		}); err != nil {
+			logger.WithError(err).Error("invalid reconcile schedule")
+			return exitFailure
+		}
+	}
+
+	// stop scheduling on SIGTERM, running jobs get the shutdown timeout to finish before they are aborted
+
+	stop, stopped := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stopped()
+
+	runCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	go func() {
+		<-stop.Done()
+		logger.WithField("timeout", conf.ShutdownTimeout.String()).Info("shutting down, waiting for running jobs")
+
+		timer := time.NewTimer(conf.ShutdownTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			logger.Warn("running jobs did not finish in time, aborting them")
+			abort()
+		case <-runCtx.Done():
+		}
+	}()
+
+	metricsCtx, stopMetrics := context.WithCancel(context.Background())
+	defer stopMetrics()
+
+	go func() {
+		if err := metrics.Serve(metricsCtx, a.conf.Metrics.ListenAddress); err != nil {
+			logger.WithError(err).Error("could not serve metrics")
+		}
+	}()
+
+	logger.WithField("jobs", len(jobs)).WithField("metrics", a.conf.Metrics.ListenAddress).Info("serving")
+
+	scheduler.New(logger, jobs).Run(stop, runCtx)
+
+	logger.Info("stopped serving")
+	return exitOK
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// stateCommand prints which MISP attributes were pushed to Sentinel.
+func stateCommand(logger *logrus.Logger, args []string) int {
+	fs := newFlagSet("state")
+	confFile := configFlag(fs)
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	a, err := newApp(logger, *confFile)
+	if err != nil {
+		logger.WithError(err).WithField("config", *confFile).Error("could not start")
+		return exitFailure
+	}
+	defer a.close()
+
+	store, err := a.openStore()
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	if err := printState(os.Stdout, store); err != nil {
+		logger.WithError(err).Error("could not print sync state")
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+// printState writes every record in the sync state as a table.
+func printState(w io.Writer, store *state.Store) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "ATTRIBUTE UUID\tEVENT UUID\tINDICATOR\tHASH\tLAST PUSHED\tREVOKED")
+
+	if err := store.Walk(func(record state.Record) error {
+		hash := record.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+
+		_, err := fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%t\n",
+			record.AttributeUUID, record.EventUUID, record.IndicatorName, hash, record.LastPushed.Format(time.RFC3339), record.Revoked)
+		return err
+	}); err != nil {
+		return fmt.Errorf("could not read sync state: %v", err)
+	}
+
+	return table.Flush()
+}
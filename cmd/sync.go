@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/sentinel"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sync"
+	"time"
+)
+
+func syncCommand(logger *logrus.Logger, args []string) int {
+	return runSyncCommand(logger, "sync", args, false)
+}
+
+func planCommand(logger *logrus.Logger, args []string) int {
+	return runSyncCommand(logger, "plan", args, true)
+}
+
+// runSyncCommand syncs and, unless skipped, cleans up at the same time. A dry run records the changes into a plan instead.
+func runSyncCommand(logger *logrus.Logger, name string, args []string, plan bool) int {
+	fs := newFlagSet(name)
+	confFile := configFlag(fs)
+	backfill := fs.Bool("backfill", false, "Fetch the full days_to_fetch window instead of only the changes since the last sync.")
+	forceRecheck := fs.Bool("recheck", false, "Recheck every pushed indicator for retractions and confidence, even before recheck_interval passed.")
+	skipCleanup := fs.Bool("skip-cleanup", false, "Do not clean up expired indicators during this sync, as skip_delete does.")
+	planFile := fs.String("plan-file", "", "Save the plan of a dry run as JSON, to make its changes later with apply.")
+	dryRun := &plan
+	if !plan {
+		dryRun = fs.Bool("dry-run", false, "Show what sync would change in Sentinel, without changing anything.")
+	}
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	a, err := newApp(logger, *confFile)
+	if err != nil {
+		logger.WithError(err).WithField("config", *confFile).Error("could not start")
+		return exitFailure
+	}
+	defer a.close()
+
+	store, err := a.openStore()
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	source, err := a.newSource()
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	sen, err := a.newSentinel(store)
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	// a dry run records the changes into a plan instead of making them
+	var sink indicator.Sink = sen
+	cleanup := sen.CleanupThreatIntel
+	setMark := store.SetHighWaterMark
+
+	var planner *sentinel.Planner
+	if *dryRun {
+		planner = sen.NewPlanner()
+		sink = planner
+		cleanup = planner.CleanupThreatIntel
+		setMark = func(mark time.Time) error {
+			planner.SetHighWaterMark(mark)
+			return nil
+		}
+	}
+
+	cutoff := a.stateCutoff()
+	if !*dryRun {
+		if err := pruneState(logger, store, cutoff); err != nil {
+			logger.WithError(err).Error("could not prune sync state")
+			return exitFailure
+		}
+	}
+
+	recheck := *forceRecheck || a.recheckDue()
+
+	taskWg := sync.WaitGroup{}
+	failed := make(chan error, 2)
+
+	if !a.conf.Sentinel.SkipDelete && !*skipCleanup {
+		taskWg.Add(1)
+		go func() {
+			defer taskWg.Done()
+
+			logger.WithField("dry_run", *dryRun).Info("cleaning up Sentinel TI")
+
+			if err := cleanup(ctx, logger, a.cleanupSources(source)); err != nil {
+				failed <- fmt.Errorf("could not clean up threat intel: %w", err)
+			}
+		}()
+	}
+
+	taskWg.Add(1)
+	go func() {
+		defer taskWg.Done()
+
+		if err := runSync(ctx, logger, store, source, sink, setMark, cutoff, *backfill, recheck); err != nil {
+			failed <- err
+			return
+		}
+
+		if !*dryRun {
+			a.syncSucceeded(recheck)
+		}
+	}()
+
+	logger.Info("waiting for tasks to finish")
+	taskWg.Wait()
+	close(failed)
+
+	code := exitOK
+	for err := range failed {
+		logger.WithError(err).Error("failed")
+		code = exitFailure
+	}
+
+	if code != exitOK {
+		return code
+	}
+
+	if planner != nil {
+		if err := writePlan(logger, os.Stdout, planner.Plan(), *planFile); err != nil {
+			logger.WithError(err).Error("could not write plan")
+			return exitFailure
+		}
+
+		return exitOK
+	}
+
+	logger.Info("submitted all TI to Sentinel")
+	return exitOK
+}
+
+// runSync fetches the source changes since the last sync and pushes them into the sink. When recheck is set it then
+// retracts withdrawn indicators and refreshes the confidence of the indicators that were pushed before.
+// setMark moves the high-water mark once everything up to it was pushed, indicators last pushed before cutoff have expired and are left alone.
+func runSync(ctx context.Context, logger *logrus.Logger, store *state.Store, source indicator.Source, sink indicator.Sink, setMark func(time.Time) error, cutoff time.Time, backfill, recheck bool) error {
+	since, err := syncWindow(logger, store, backfill)
+	if err != nil {
+		return err
+	}
+
+	// stream threat intelligence from the source into the sink
+
+	logger.WithField("source", source.Name()).Info("submitting indicators to MS Sentinel")
+
+	latest, err := indicator.Stream(ctx, logger, source, sink, since)
+	if err != nil {
+		return fmt.Errorf("failed to submit indicators: %w", err)
+	}
+
+	// only move the high-water mark forward once everything up to it was pushed
+
+	if latest.After(since) {
+		if err := setMark(latest); err != nil {
+			return err
+		}
+	}
+
+	// retractions, new sightings and decay do not show up in the incremental fetch, so the pushed indicators are rechecked
+
+	if !recheck {
+		return nil
+	}
+
+	return recheckPushed(ctx, logger, store, source, sink, cutoff)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// createTableCommand creates the vulnerabilities table in the Log Analytics workspace.
+func createTableCommand(logger *logrus.Logger, args []string) int {
+	fs := newFlagSet("create-table")
+	confFile := configFlag(fs)
+	retentionDays := fs.Uint("retention-days", 90, "The interactive retention of the table in days, total retention is twice as long.")
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	a, err := newApp(logger, *confFile)
+	if err != nil {
+		logger.WithError(err).WithField("config", *confFile).Error("could not start")
+		return exitFailure
+	}
+	defer a.close()
+
+	sen, err := a.newSentinel(nil)
+	if err != nil {
+		logger.WithError(err).Error("could not start")
+		return exitFailure
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	if err := sen.CreateTable(ctx, logger, uint32(*retentionDays)); err != nil {
+		logger.WithError(err).Error("could not create table")
+		return exitFailure
+	}
+
+	return exitOK
+}
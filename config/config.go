@@ -4,18 +4,65 @@ import (
 	"fmt"
 	validator "github.com/asaskevich/govalidator"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 	"os"
+	"strings"
+	"time"
 )
 
 const (
 	defaultLogLevel      = "INFO"
 	defaultExpiresMonths = 6
+	defaultStatePath     = "mispsent.db"
+	defaultMaxTLP        = "red"
+
+	defaultMispRequestTimeout  = 15 * time.Minute
+	defaultMispRecheckInterval = 24 * time.Hour
+
+	defaultWorkers              = 4
+	defaultMaxRequestsPerSecond = 10
+
+	defaultSyncInterval    = time.Hour
+	defaultCleanupInterval = 24 * time.Hour
+	defaultShutdownTimeout = 5 * time.Minute
+
+	defaultMetricsListenAddress = ":9090"
+
+	defaultConfidence                = 50
+	defaultConfidenceDecayWeight     = 0.4
+	defaultConfidenceSightingWeight  = 0.2
+	defaultConfidenceTagWeight       = 0.4
+	defaultConfidenceSightingsForMax = 10
+
+	SubmitAPIARM    = "arm"
+	SubmitAPIUpload = "upload"
+
+	RevokeActionRevoke = "revoke"
+	RevokeActionDelete = "delete"
+
+	AuthClientSecret      = "client_secret"
+	AuthClientCertificate = "client_certificate"
+	AuthManagedIdentity   = "managed_identity"
+	AuthWorkloadIdentity  = "workload_identity"
+	AuthEnvironment       = "environment"
+	AuthAzureCLI          = "azure_cli"
+	AuthDefault           = "default"
+
+	CloudPublic     = "public"
+	CloudGovernment = "usgovernment"
+	CloudChina      = "china"
+	CloudCustom     = "custom"
 )
 
 var (
-	defaultMispTypesToFetch = []string{"ip-dst", "hostname", "domain", "sha256"}
+	defaultMispTypesToFetch = []string{
+		"ip-dst", "hostname", "domain", "sha256",
+		"domain|ip", "filename|sha256", "ip-dst|port", "hostname|port",
+	}
+	defaultMispObjectsToFetch = []string{"file", "domain-ip", "url", "email"}
+	validTLPLevels            = []string{"clear", "white", "green", "amber", "amber+strict", "red"}
 )
 
 type Config struct {
@@ -24,22 +71,120 @@ type Config struct {
 	} `yaml:"log"`
 
 	MISP struct {
-		BaseURL      string   `yaml:"base_url" envconfig:"MISP_BASE_URL" valid:"url"`
+		BaseURL string `yaml:"base_url" envconfig:"MISP_BASE_URL" valid:"url"`
+		// LinkBaseURL is the MISP URL that Sentinel indicators link to, defaults to base_url
+		LinkBaseURL  string   `yaml:"link_base_url" envconfig:"MISP_LINK_BASE_URL" valid:"url"`
 		AccessKey    string   `yaml:"access_key" envconfig:"MISP_ACCESS_KEY" valid:"minstringlength(3)"`
 		DaysToFetch  uint32   `yaml:"days_to_fetch" envconfig:"MISP_DAYS_TO_FETCH"`
 		TypesToFetch []string `yaml:"types_to_fetch" envconfig:"MISP_TYPES_FETCH"`
+		// ObjectsToFetch are the MISP object names that are exported as a single indicator each
+		ObjectsToFetch []string `yaml:"objects_to_fetch" envconfig:"MISP_OBJECTS_FETCH"`
+		SkipObjects    bool     `yaml:"skip_objects" envconfig:"MISP_SKIP_OBJECTS"`
+		// MaxTLP is the most restrictive TLP level that is still exported to Sentinel
+		MaxTLP string `yaml:"max_tlp" envconfig:"MISP_MAX_TLP"`
+		// RequestTimeout bounds every single MISP request, FetchTimeout all MISP requests of a run
+		RequestTimeout time.Duration `yaml:"request_timeout" envconfig:"MISP_REQUEST_TIMEOUT"`
+		FetchTimeout   time.Duration `yaml:"fetch_timeout" envconfig:"MISP_FETCH_TIMEOUT"`
+		// MaxRetries of failed MISP requests, which back off exponentially from RetryBaseDelay up to RetryMaxDelay
+		MaxRetries     int           `yaml:"max_retries" envconfig:"MISP_MAX_RETRIES"`
+		RetryBaseDelay time.Duration `yaml:"retry_base_delay" envconfig:"MISP_RETRY_BASE_DELAY"`
+		RetryMaxDelay  time.Duration `yaml:"retry_max_delay" envconfig:"MISP_RETRY_MAX_DELAY"`
+		// RecheckInterval is how often a sync fetches every pushed indicator again, for retractions and new confidence
+		RecheckInterval time.Duration `yaml:"recheck_interval" envconfig:"MISP_RECHECK_INTERVAL"`
 	} `yaml:"misp"`
 
 	Sentinel struct {
-		AppID          string `yaml:"app_id" envconfig:"MS_APP_ID" valid:"minstringlength(3)"`
-		SecretKey      string `yaml:"secret_key" envconfig:"MS_SECRET_KEY" valid:"minstringlength(3)"`
-		TenantID       string `yaml:"tenant_id" envconfig:"MS_TENANT_ID" valid:"minstringlength(3)"`
+		// AuthMethod is one of client_secret (default), client_certificate, managed_identity, workload_identity, environment, azure_cli or default
+		AuthMethod          string `yaml:"auth_method" envconfig:"MS_AUTH_METHOD"`
+		AppID               string `yaml:"app_id" envconfig:"MS_APP_ID"`
+		SecretKey           string `yaml:"secret_key" envconfig:"MS_SECRET_KEY"`
+		TenantID            string `yaml:"tenant_id" envconfig:"MS_TENANT_ID"`
+		CertificatePath     string `yaml:"certificate_path" envconfig:"MS_CERT_PATH"`
+		CertificatePassword string `yaml:"certificate_password" envconfig:"MS_CERT_PASSWORD"`
+		FederatedTokenFile  string `yaml:"federated_token_file" envconfig:"MS_FEDERATED_TOKEN_FILE"`
+
 		SubscriptionID string `yaml:"subscription_id" envconfig:"MS_SUB_ID" valid:"minstringlength(3)"`
 		ResourceGroup  string `yaml:"resource_group" envconfig:"MS_RES_GROUP" valid:"minstringlength(3)"`
 		WorkspaceName  string `yaml:"workspace_name" envconfig:"MS_WS_NAME" valid:"minstringlength(3)"`
+		WorkspaceID    string `yaml:"workspace_id" envconfig:"MS_WS_ID"`
+		// Cloud is one of public (default), usgovernment, china or custom
+		Cloud          string `yaml:"cloud" envconfig:"MS_CLOUD"`
+		AuthorityHost  string `yaml:"authority_host" envconfig:"MS_AUTHORITY_HOST"`
+		ARMEndpoint    string `yaml:"arm_endpoint" envconfig:"MS_ARM_ENDPOINT"`
+		UploadEndpoint string `yaml:"upload_endpoint" envconfig:"MS_UPLOAD_ENDPOINT"`
+		SubmitAPI      string `yaml:"submit_api" envconfig:"MS_SUBMIT_API"`
+		RevokeAction   string `yaml:"revoke_action" envconfig:"MS_REVOKE_ACTION"`
 		ExpiresMonths  int    `yaml:"expires_months" envconfig:"MS_EXPIRES_MONTHS"`
 		SkipDelete     bool   `yaml:"skip_delete" envconfig:"MS_SKIP_DELETE"`
+		// Workers is the amount of indicators that are created, updated or deleted concurrently
+		Workers int `yaml:"workers" envconfig:"MS_WORKERS"`
+		// MaxRequestsPerSecond caps the Sentinel request rate, which is lowered automatically when Azure throttles.
+		// It defaults to 10, a negative value does not cap the rate but still backs off when Azure throttles.
+		MaxRequestsPerSecond float64 `yaml:"max_requests_per_second" envconfig:"MS_MAX_REQUESTS_PER_SECOND"`
+		// CleanupSources limits cleanup to indicators of these sources, defaults to the MISP hostname
+		CleanupSources []string `yaml:"cleanup_sources" envconfig:"MS_CLEANUP_SOURCES"`
 	} `yaml:"mssentinel"`
+
+	// Confidence weighs the MISP decay score, sightings and confidence tags into the indicator confidence
+	Confidence struct {
+		Default         int     `yaml:"default" envconfig:"CONFIDENCE_DEFAULT"`
+		DecayWeight     float64 `yaml:"decay_weight" envconfig:"CONFIDENCE_DECAY_WEIGHT"`
+		SightingWeight  float64 `yaml:"sighting_weight" envconfig:"CONFIDENCE_SIGHTING_WEIGHT"`
+		TagWeight       float64 `yaml:"tag_weight" envconfig:"CONFIDENCE_TAG_WEIGHT"`
+		SightingsForMax int     `yaml:"sightings_for_max" envconfig:"CONFIDENCE_SIGHTINGS_MAX"`
+	} `yaml:"confidence"`
+
+	State struct {
+		Path string `yaml:"path" envconfig:"STATE_PATH"`
+	} `yaml:"state"`
+
+	Metrics struct {
+		// ListenAddress is where serve exposes the Prometheus metrics
+		ListenAddress string `yaml:"listen_address" envconfig:"METRICS_LISTEN_ADDRESS"`
+		// Textfile is written with the metrics when a command finishes, for the node exporter textfile collector
+		Textfile string `yaml:"textfile" envconfig:"METRICS_TEXTFILE"`
+	} `yaml:"metrics"`
+
+	// Serve schedules the jobs of the serve command
+	Serve struct {
+		Sync    Schedule `yaml:"sync" envconfig:"SERVE_SYNC"`
+		Cleanup Schedule `yaml:"cleanup" envconfig:"SERVE_CLEANUP"`
+		// Reconcile only runs when it has an interval or cron expression
+		Reconcile    Schedule `yaml:"reconcile" envconfig:"SERVE_RECONCILE"`
+		ReconcileFix bool     `yaml:"reconcile_fix" envconfig:"SERVE_RECONCILE_FIX"`
+		// ShutdownTimeout is how long running jobs may take to finish after a SIGTERM before they are aborted
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout" envconfig:"SERVE_SHUTDOWN_TIMEOUT"`
+	} `yaml:"serve"`
+}
+
+// Schedule runs a job every interval or on a standard cron expression, delayed by a random jitter.
+type Schedule struct {
+	Interval time.Duration `yaml:"interval" envconfig:"INTERVAL"`
+	Cron     string        `yaml:"cron" envconfig:"CRON"`
+	Jitter   time.Duration `yaml:"jitter" envconfig:"JITTER"`
+}
+
+// Enabled returns whether the schedule has an interval or cron expression.
+func (s Schedule) Enabled() bool {
+	return s.Interval != 0 || s.Cron != ""
+}
+
+func (s Schedule) validate(name string) error {
+	if s.Interval != 0 && s.Cron != "" {
+		return fmt.Errorf("the %s schedule has both an interval and a cron expression", name)
+	}
+
+	if s.Interval < 0 || s.Jitter < 0 {
+		return fmt.Errorf("the %s schedule interval and jitter cannot be negative", name)
+	}
+
+	if s.Cron != "" {
+		if _, err := cron.ParseStandard(s.Cron); err != nil {
+			return fmt.Errorf("invalid %s cron expression '%s': %v", name, s.Cron, err)
+		}
+	}
+
+	return nil
 }
 
 func (c *Config) Validate() error {
@@ -51,14 +196,187 @@ func (c *Config) Validate() error {
 		c.Sentinel.ExpiresMonths = defaultExpiresMonths
 	}
 
+	if c.State.Path == "" {
+		c.State.Path = defaultStatePath
+	}
+
+	if c.Sentinel.AuthMethod == "" {
+		c.Sentinel.AuthMethod = AuthClientSecret
+	}
+
+	switch c.Sentinel.AuthMethod {
+	case AuthClientSecret:
+		if len(c.Sentinel.AppID) < 3 || len(c.Sentinel.SecretKey) < 3 || len(c.Sentinel.TenantID) < 3 {
+			return fmt.Errorf("the client secret auth method requires app_id, secret_key and tenant_id")
+		}
+	case AuthClientCertificate:
+		if len(c.Sentinel.AppID) < 3 || len(c.Sentinel.TenantID) < 3 || c.Sentinel.CertificatePath == "" {
+			return fmt.Errorf("the client certificate auth method requires app_id, tenant_id and certificate_path")
+		}
+	case AuthManagedIdentity, AuthWorkloadIdentity, AuthEnvironment, AuthAzureCLI, AuthDefault:
+	default:
+		return fmt.Errorf("invalid auth method '%s'", c.Sentinel.AuthMethod)
+	}
+
+	if c.Sentinel.Cloud == "" {
+		c.Sentinel.Cloud = CloudPublic
+	}
+
+	c.Sentinel.Cloud = strings.ToLower(c.Sentinel.Cloud)
+
+	switch c.Sentinel.Cloud {
+	case CloudPublic, CloudGovernment, CloudChina:
+	case CloudCustom:
+		if c.Sentinel.AuthorityHost == "" || c.Sentinel.ARMEndpoint == "" {
+			return fmt.Errorf("the custom cloud requires authority_host and arm_endpoint")
+		}
+	default:
+		return fmt.Errorf("invalid cloud '%s'", c.Sentinel.Cloud)
+	}
+
+	if c.Sentinel.SubmitAPI == "" {
+		c.Sentinel.SubmitAPI = SubmitAPIARM
+	}
+
+	switch c.Sentinel.SubmitAPI {
+	case SubmitAPIARM:
+	case SubmitAPIUpload:
+		if c.Sentinel.WorkspaceID == "" {
+			return fmt.Errorf("the upload submit API requires a workspace ID")
+		}
+
+		if c.Sentinel.Cloud != CloudPublic && c.Sentinel.UploadEndpoint == "" {
+			return fmt.Errorf("the upload submit API requires upload_endpoint outside of the public cloud")
+		}
+	default:
+		return fmt.Errorf("invalid submit API '%s', must be '%s' or '%s'", c.Sentinel.SubmitAPI, SubmitAPIARM, SubmitAPIUpload)
+	}
+
+	if c.Sentinel.Workers == 0 {
+		c.Sentinel.Workers = defaultWorkers
+	}
+
+	if c.Sentinel.Workers < 0 {
+		return fmt.Errorf("workers cannot be negative")
+	}
+
+	// zero is the unset value and takes the default, a negative rate is how the cap is turned off
+	if c.Sentinel.MaxRequestsPerSecond == 0 {
+		c.Sentinel.MaxRequestsPerSecond = defaultMaxRequestsPerSecond
+	}
+
+	if c.Sentinel.RevokeAction == "" {
+		c.Sentinel.RevokeAction = RevokeActionRevoke
+	}
+
+	if c.Sentinel.RevokeAction != RevokeActionRevoke && c.Sentinel.RevokeAction != RevokeActionDelete {
+		return fmt.Errorf("invalid revoke action '%s', must be '%s' or '%s'", c.Sentinel.RevokeAction, RevokeActionRevoke, RevokeActionDelete)
+	}
+
 	if len(c.MISP.TypesToFetch) == 0 {
 		c.MISP.TypesToFetch = defaultMispTypesToFetch
 	}
 
+	if len(c.MISP.ObjectsToFetch) == 0 {
+		c.MISP.ObjectsToFetch = defaultMispObjectsToFetch
+	}
+
+	if c.MISP.RequestTimeout == 0 {
+		c.MISP.RequestTimeout = defaultMispRequestTimeout
+	}
+
+	if c.MISP.RequestTimeout < 0 || c.MISP.FetchTimeout < 0 {
+		return fmt.Errorf("MISP timeouts cannot be negative")
+	}
+
+	if c.MISP.RetryBaseDelay < 0 || c.MISP.RetryMaxDelay < 0 {
+		return fmt.Errorf("MISP retry delays cannot be negative")
+	}
+
+	if c.MISP.RecheckInterval == 0 {
+		c.MISP.RecheckInterval = defaultMispRecheckInterval
+	}
+
+	if c.MISP.RecheckInterval < 0 {
+		return fmt.Errorf("MISP recheck interval cannot be negative")
+	}
+
+	if c.MISP.MaxTLP == "" {
+		c.MISP.MaxTLP = defaultMaxTLP
+	}
+
+	c.MISP.MaxTLP = strings.ToLower(c.MISP.MaxTLP)
+
+	validTLP := false
+	for _, level := range validTLPLevels {
+		if level == c.MISP.MaxTLP {
+			validTLP = true
+			break
+		}
+	}
+
+	if !validTLP {
+		return fmt.Errorf("invalid max TLP '%s', must be one of %s", c.MISP.MaxTLP, strings.Join(validTLPLevels, ", "))
+	}
+
+	if c.Confidence.Default == 0 {
+		c.Confidence.Default = defaultConfidence
+	}
+
+	if c.Confidence.DecayWeight == 0 && c.Confidence.SightingWeight == 0 && c.Confidence.TagWeight == 0 {
+		c.Confidence.DecayWeight = defaultConfidenceDecayWeight
+		c.Confidence.SightingWeight = defaultConfidenceSightingWeight
+		c.Confidence.TagWeight = defaultConfidenceTagWeight
+	}
+
+	if c.Confidence.SightingsForMax == 0 {
+		c.Confidence.SightingsForMax = defaultConfidenceSightingsForMax
+	}
+
+	if c.Confidence.Default < 0 || c.Confidence.Default > 100 {
+		return fmt.Errorf("invalid default confidence %d, must be between 0 and 100", c.Confidence.Default)
+	}
+
+	if c.Confidence.DecayWeight < 0 || c.Confidence.SightingWeight < 0 || c.Confidence.TagWeight < 0 {
+		return fmt.Errorf("confidence weights cannot be negative")
+	}
+
+	if !c.Serve.Sync.Enabled() {
+		c.Serve.Sync.Interval = defaultSyncInterval
+	}
+
+	if !c.Serve.Cleanup.Enabled() {
+		c.Serve.Cleanup.Interval = defaultCleanupInterval
+	}
+
+	if c.Metrics.ListenAddress == "" {
+		c.Metrics.ListenAddress = defaultMetricsListenAddress
+	}
+
+	if c.Serve.ShutdownTimeout == 0 {
+		c.Serve.ShutdownTimeout = defaultShutdownTimeout
+	}
+
+	if err := c.Serve.Sync.validate("sync"); err != nil {
+		return err
+	}
+
+	if err := c.Serve.Cleanup.validate("cleanup"); err != nil {
+		return err
+	}
+
+	if err := c.Serve.Reconcile.validate("reconcile"); err != nil {
+		return err
+	}
+
 	if c.MISP.BaseURL == "" {
 		return fmt.Errorf("no MISP base url provided")
 	}
 
+	if c.MISP.LinkBaseURL == "" {
+		c.MISP.LinkBaseURL = c.MISP.BaseURL
+	}
+
 	if valid, err := validator.ValidateStruct(c); !valid || err != nil {
 		return fmt.Errorf("invalid configuration: %v", err)
 	}
@@ -0,0 +1,98 @@
+package indicator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Observable is a single typed value, types follow the MISP attribute type vocabulary such as ip-dst or sha256.
+type Observable struct {
+	Type  string
+	Value string
+	// Relation names the role of the value within a composite indicator, e.g. sha256 of a file
+	Relation string
+}
+
+// Group is the event or report the indicator was published in.
+type Group struct {
+	ID   string
+	UUID string
+	Info string
+	// Links are references to further reading that were published along with the indicator
+	Links []string
+}
+
+// Cluster is a threat actor, malware, tool or attack pattern the indicator is associated with.
+type Cluster struct {
+	Type        string
+	Value       string
+	ExternalIDs []string
+	// KillChain contains the kill chain phases as <kill chain>:<phase>, e.g. mitre-attack:initial-access
+	KillChain []string
+}
+
+// Indicator is a source-neutral threat intelligence indicator.
+type Indicator struct {
+	// ID identifies the indicator within its source
+	ID   string
+	UUID string
+	// Composite is set when the indicator matches on several observables together, such as a file with its hashes
+	Composite bool
+	// Type is the observable type, or the kind of composite such as file or domain-ip
+	Type        string
+	Category    string
+	Observables []Observable
+	Description string
+	Deleted     bool
+
+	Modified time.Time
+	// LastSeen is zero when the source does not know when the indicator was last seen
+	LastSeen time.Time
+
+	Group    Group
+	Tags     []string
+	Clusters []Cluster
+
+	DecayScores    []float64
+	Sightings      int
+	FalsePositives int
+
+	// Hash fingerprints the source contents so unchanged indicators can be skipped
+	Hash string
+}
+
+// Value returns the value of the first observable.
+func (i Indicator) Value() string {
+	if len(i.Observables) == 0 {
+		return ""
+	}
+
+	return i.Observables[0].Value
+}
+
+// LatestModified returns the most recent modification time of the indicators.
+func LatestModified(indicators []Indicator) time.Time {
+	latest := time.Time{}
+
+	for _, indicator := range indicators {
+		if indicator.Modified.After(latest) {
+			latest = indicator.Modified
+		}
+	}
+
+	return latest
+}
+
+// ContentHash fingerprints the JSON encoding of v, so unchanged indicators can be skipped.
+func ContentHash(v interface{}) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		// content that cannot be hashed is always considered changed
+		return ""
+	}
+
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,74 @@
+package indicator
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	RetractionDeleted     = "deleted"
+	RetractionNotIDS      = "to_ids disabled"
+	RetractionWarninglist = "warninglist hit"
+	RetractionRemoved     = "removed"
+	RetractionTLP         = "above max TLP"
+)
+
+// Retraction describes why a previously exported indicator should no longer be active.
+type Retraction struct {
+	Reason string
+	// Indicator is set when the retracted indicator still exists in the source.
+	Indicator *Indicator
+}
+
+// Ref points to a previously exported indicator.
+type Ref struct {
+	UUID      string
+	Composite bool
+}
+
+// Source is a threat intelligence feed that indicators are exported from.
+type Source interface {
+	// Name identifies the source on the indicators it exports
+	Name() string
+	// FetchIndicators fetches the indicators changed since the given time, or the full window when since is zero.
+	// Indicators are passed to fn page by page as they are fetched, fetching stops at the first error fn returns.
+	FetchIndicators(ctx context.Context, since time.Time, fn func([]Indicator) error) error
+	// FetchCurrent fetches the current version of the referenced indicators that are still active, page by page.
+	FetchCurrent(ctx context.Context, refs []Ref, fn func([]Indicator) error) error
+	// FetchRetractions returns the referenced indicators that should no longer be active, keyed by UUID.
+	FetchRetractions(ctx context.Context, refs []Ref) (map[string]Retraction, error)
+}
+
+// RejectedError is returned by a sink that submitted every indicator except the ones it rejected.
+type RejectedError struct {
+	Rejected int
+	// Earliest is the earliest modification time of the rejected indicators, the high-water mark is held back to it
+	Earliest time.Time
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("%d indicators were rejected", e.Rejected)
+}
+
+// Add records rejected indicators that were last modified at the given time.
+func (e *RejectedError) Add(rejected int, modified time.Time) {
+	if rejected == 0 {
+		return
+	}
+
+	if e.Rejected == 0 || modified.Before(e.Earliest) {
+		e.Earliest = modified
+	}
+
+	e.Rejected += rejected
+}
+
+// Sink is a destination that indicators are exported to.
+type Sink interface {
+	// SubmitThreatIntel submits the pages of indicators until the channel is closed or submitting fails.
+	// Indicators that were rejected while the others were submitted are reported as a *RejectedError.
+	SubmitThreatIntel(ctx context.Context, l *logrus.Logger, source string, pages <-chan []Indicator) error
+	RevokeThreatIntel(ctx context.Context, l *logrus.Logger, source string, retractions map[string]Retraction) error
+}
@@ -0,0 +1,150 @@
+package indicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// streamBuffer is the amount of fetched pages that may wait for the sink before fetching blocks.
+const streamBuffer = 4
+
+// Stream fetches the indicators of the source and submits them to the sink page by page, so fetching and submitting
+// overlap and at most a few pages are held in memory. It returns the latest modification time of the submitted indicators,
+// held back to before the earliest indicator the sink rejected so the next run fetches it again.
+func Stream(ctx context.Context, l *logrus.Logger, source Source, sink Sink, since time.Time) (time.Time, error) {
+	latest, err := stream(ctx, l, source, sink, func(ctx context.Context, fn func([]Indicator) error) error {
+		return source.FetchIndicators(ctx, since, fn)
+	})
+
+	var rejected *RejectedError
+	if errors.As(err, &rejected) {
+		l.WithField("source", source.Name()).WithField("rejected", rejected.Rejected).
+			WithField("earliest", rejected.Earliest.Format(time.RFC3339)).
+			Warn("indicators were rejected, holding back the high-water mark to fetch them again")
+
+		if held := rejected.Earliest.Add(-time.Second); held.Before(latest) {
+			latest = held
+		}
+
+		return latest, nil
+	}
+
+	return latest, err
+}
+
+// Recheck fetches the current version of the referenced indicators once and submits them to the sink page by page,
+// so the sink can update what changed without a new modification time. The referenced indicators the source no longer exports
+// are looked up for why, and revoked in the sink when the source retracted them. Rejected indicators are rechecked by the next run.
+func Recheck(ctx context.Context, l *logrus.Logger, source Source, sink Sink, refs []Ref) error {
+	current := make(map[string]bool, len(refs))
+
+	_, err := stream(ctx, l, source, sink, func(ctx context.Context, fn func([]Indicator) error) error {
+		return source.FetchCurrent(ctx, refs, func(page []Indicator) error {
+			for _, indicator := range page {
+				current[indicator.UUID] = true
+			}
+
+			return fn(page)
+		})
+	})
+
+	var rejected *RejectedError
+	if errors.As(err, &rejected) {
+		l.WithField("source", source.Name()).WithField("rejected", rejected.Rejected).
+			Warn("rechecked indicators were rejected")
+	} else if err != nil {
+		return err
+	}
+
+	missing := make([]Ref, 0)
+	for _, ref := range refs {
+		if !current[ref.UUID] {
+			missing = append(missing, ref)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	retractions, err := source.FetchRetractions(ctx, missing)
+	if err != nil {
+		return fmt.Errorf("could not fetch retractions from %s: %v", source.Name(), err)
+	}
+
+	if len(retractions) == 0 {
+		return nil
+	}
+
+	if err := sink.RevokeThreatIntel(ctx, l, source.Name(), retractions); err != nil {
+		return fmt.Errorf("could not revoke retracted indicators: %v", err)
+	}
+
+	return nil
+}
+
+// stream passes the pages of fetch on to the sink and returns the latest modification time of the fetched indicators.
+func stream(ctx context.Context, l *logrus.Logger, source Source, sink Sink, fetch func(context.Context, func([]Indicator) error) error) (time.Time, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pages := make(chan []Indicator, streamBuffer)
+	fetchErr := make(chan error, 1)
+
+	latest := time.Time{}
+	numFetched := 0
+
+	go func() {
+		defer close(pages)
+
+		fetchErr <- fetch(ctx, func(page []Indicator) error {
+			if modified := LatestModified(page); modified.After(latest) {
+				latest = modified
+			}
+
+			numFetched += len(page)
+
+			select {
+			case pages <- page:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	submitErr := sink.SubmitThreatIntel(ctx, l, source.Name(), pages)
+
+	// stop fetching when the sink gave up before the source was done
+	cancel()
+
+	var rejected *RejectedError
+	if err := <-fetchErr; (submitErr == nil || errors.As(submitErr, &rejected)) && err != nil {
+		return time.Time{}, fmt.Errorf("could not fetch indicators from %s: %v", source.Name(), err)
+	}
+
+	if submitErr != nil && rejected == nil {
+		return time.Time{}, submitErr
+	}
+
+	l.WithField("source", source.Name()).WithField("indicators", numFetched).Debug("streamed indicators")
+
+	return latest, submitErr
+}
+
+// Collect fetches all indicators of the source at once, for when the full set is needed such as to reconcile.
+func Collect(ctx context.Context, source Source, since time.Time) ([]Indicator, error) {
+	indicators := make([]Indicator, 0)
+
+	if err := source.FetchIndicators(ctx, since, func(page []Indicator) error {
+		indicators = append(indicators, page...)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("could not fetch indicators from %s: %v", source.Name(), err)
+	}
+
+	return indicators, nil
+}
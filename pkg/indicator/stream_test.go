@@ -0,0 +1,251 @@
+package indicator
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeSource passes its pages to the stream one by one and fails after them when fetchErr is set.
+// It retracts the looked up indicators that are in retracted, and records which were looked up.
+type fakeSource struct {
+	pages     [][]Indicator
+	fetchErr  error
+	retracted map[string]bool
+	lookedUp  []string
+}
+
+func (f *fakeSource) Name() string {
+	return "fake"
+}
+
+func (f *fakeSource) FetchIndicators(ctx context.Context, _ time.Time, fn func([]Indicator) error) error {
+	for _, page := range f.pages {
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+
+	return f.fetchErr
+}
+
+func (f *fakeSource) FetchCurrent(ctx context.Context, _ []Ref, fn func([]Indicator) error) error {
+	return f.FetchIndicators(ctx, time.Time{}, fn)
+}
+
+func (f *fakeSource) FetchRetractions(_ context.Context, refs []Ref) (map[string]Retraction, error) {
+	retractions := make(map[string]Retraction)
+
+	for _, ref := range refs {
+		f.lookedUp = append(f.lookedUp, ref.UUID)
+
+		if f.retracted[ref.UUID] {
+			retractions[ref.UUID] = Retraction{Reason: RetractionRemoved}
+		}
+	}
+
+	return retractions, nil
+}
+
+// fakeSink consumes every page and then returns submitErr, it counts the revoked indicators.
+type fakeSink struct {
+	submitted int
+	submitErr error
+	revoked   int
+}
+
+func (f *fakeSink) SubmitThreatIntel(_ context.Context, _ *logrus.Logger, _ string, pages <-chan []Indicator) error {
+	for page := range pages {
+		f.submitted += len(page)
+	}
+
+	return f.submitErr
+}
+
+func (f *fakeSink) RevokeThreatIntel(_ context.Context, _ *logrus.Logger, _ string, retractions map[string]Retraction) error {
+	f.revoked += len(retractions)
+	return nil
+}
+
+func TestStream(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	pages := [][]Indicator{
+		{{UUID: "a", Modified: day}, {UUID: "b", Modified: day.Add(2 * time.Hour)}},
+		{{UUID: "c", Modified: day.Add(time.Hour)}},
+	}
+
+	sinkErr := errors.New("sink down")
+
+	tests := []struct {
+		name          string
+		source        *fakeSource
+		sink          *fakeSink
+		wantLatest    time.Time
+		wantSubmitted int
+		wantErr       bool
+	}{
+		{
+			name:          "latest modification",
+			source:        &fakeSource{pages: pages},
+			sink:          &fakeSink{},
+			wantLatest:    day.Add(2 * time.Hour),
+			wantSubmitted: 3,
+		},
+		{
+			name:       "nothing fetched",
+			source:     &fakeSource{},
+			sink:       &fakeSink{},
+			wantLatest: time.Time{},
+		},
+		{
+			name:          "rejected holds back the mark",
+			source:        &fakeSource{pages: pages},
+			sink:          &fakeSink{submitErr: &RejectedError{Rejected: 1, Earliest: day.Add(time.Hour)}},
+			wantLatest:    day.Add(time.Hour - time.Second),
+			wantSubmitted: 3,
+		},
+		{
+			name:          "rejected after the latest keeps the mark",
+			source:        &fakeSource{pages: pages},
+			sink:          &fakeSink{submitErr: &RejectedError{Rejected: 1, Earliest: day.Add(3 * time.Hour)}},
+			wantLatest:    day.Add(2 * time.Hour),
+			wantSubmitted: 3,
+		},
+		{
+			name:    "sink error",
+			source:  &fakeSource{pages: pages},
+			sink:    &fakeSink{submitErr: sinkErr},
+			wantErr: true,
+		},
+		{
+			name:    "fetch error",
+			source:  &fakeSource{pages: pages, fetchErr: errors.New("misp down")},
+			sink:    &fakeSink{},
+			wantErr: true,
+		},
+		{
+			name:    "fetch error with rejections",
+			source:  &fakeSource{pages: pages, fetchErr: errors.New("misp down")},
+			sink:    &fakeSink{submitErr: &RejectedError{Rejected: 1, Earliest: day}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			latest, err := Stream(context.Background(), logger, tt.source, tt.sink, time.Time{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Stream() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				if !latest.IsZero() {
+					t.Errorf("Stream() = %s on error, want no high-water mark", latest)
+				}
+				return
+			}
+
+			if !latest.Equal(tt.wantLatest) {
+				t.Errorf("Stream() = %s, want %s", latest, tt.wantLatest)
+			}
+
+			if tt.sink.submitted != tt.wantSubmitted {
+				t.Errorf("sink got %d indicators, want %d", tt.sink.submitted, tt.wantSubmitted)
+			}
+		})
+	}
+}
+
+func TestRecheck(t *testing.T) {
+	pages := [][]Indicator{{{UUID: "a"}, {UUID: "b"}}, {{UUID: "c"}}}
+	refs := []Ref{{UUID: "a"}, {UUID: "b"}, {UUID: "c"}, {UUID: "d"}, {UUID: "e", Composite: true}}
+
+	tests := []struct {
+		name          string
+		source        *fakeSource
+		sink          *fakeSink
+		wantLookedUp  []string
+		wantSubmitted int
+		wantRevoked   int
+		wantErr       bool
+	}{
+		{
+			name:          "only missing indicators are looked up",
+			source:        &fakeSource{pages: pages, retracted: map[string]bool{"d": true}},
+			sink:          &fakeSink{},
+			wantLookedUp:  []string{"d", "e"},
+			wantSubmitted: 3,
+			wantRevoked:   1,
+		},
+		{
+			name:          "rejected still revokes",
+			source:        &fakeSource{pages: pages, retracted: map[string]bool{"d": true, "e": true}},
+			sink:          &fakeSink{submitErr: &RejectedError{Rejected: 1}},
+			wantLookedUp:  []string{"d", "e"},
+			wantSubmitted: 3,
+			wantRevoked:   2,
+		},
+		{
+			name:          "nothing missing",
+			source:        &fakeSource{pages: append(pages, []Indicator{{UUID: "d"}, {UUID: "e"}})},
+			sink:          &fakeSink{},
+			wantSubmitted: 5,
+		},
+		{
+			name:    "fetch error revokes nothing",
+			source:  &fakeSource{pages: pages, fetchErr: errors.New("misp down"), retracted: map[string]bool{"d": true}},
+			sink:    &fakeSink{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			err := Recheck(context.Background(), logger, tt.source, tt.sink, refs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Recheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(tt.source.lookedUp, tt.wantLookedUp) {
+				t.Errorf("looked up %v for retractions, want %v", tt.source.lookedUp, tt.wantLookedUp)
+			}
+
+			if tt.wantErr {
+				if tt.sink.revoked != 0 {
+					t.Errorf("revoked %d indicators on error, want none", tt.sink.revoked)
+				}
+				return
+			}
+
+			if tt.sink.submitted != tt.wantSubmitted || tt.sink.revoked != tt.wantRevoked {
+				t.Errorf("sink got %d indicators and revoked %d, want %d and %d",
+					tt.sink.submitted, tt.sink.revoked, tt.wantSubmitted, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+func TestRejectedErrorAdd(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	rejected := &RejectedError{}
+	rejected.Add(0, day.Add(-time.Hour))
+	rejected.Add(2, day)
+	rejected.Add(1, day.Add(-time.Minute))
+	rejected.Add(1, day.Add(time.Hour))
+
+	if rejected.Rejected != 4 || !rejected.Earliest.Equal(day.Add(-time.Minute)) {
+		t.Errorf("got %d rejected since %s, want 4 since %s", rejected.Rejected, rejected.Earliest, day.Add(-time.Minute))
+	}
+}
@@ -0,0 +1,94 @@
+package indicator
+
+import (
+	"strings"
+)
+
+const (
+	tlpPrefix = "tlp:"
+	papPrefix = "pap:"
+)
+
+// TLPLevels are the known TLP levels, from least to most restrictive.
+var TLPLevels = []string{"clear", "green", "amber", "amber+strict", "red"}
+
+// PAPLevels are the known PAP levels, from least to most restrictive.
+var PAPLevels = []string{"clear", "green", "amber", "red"}
+
+// TLP returns the most restrictive TLP level in the tags, or an empty string when there is none.
+func TLP(tags []string) string {
+	return highestLevel(tags, tlpPrefix, TLPLevels)
+}
+
+// PAP returns the most restrictive PAP level in the tags, or an empty string when there is none.
+func PAP(tags []string) string {
+	return highestLevel(tags, papPrefix, PAPLevels)
+}
+
+// TLPRank returns the position of the level in TLPLevels, or -1 when it is unknown.
+func TLPRank(level string) int {
+	return levelRank(normalizeLevel(level), TLPLevels)
+}
+
+// AboveTLP returns whether the tags carry a TLP level that is more restrictive than maxLevel.
+func AboveTLP(tags []string, maxLevel string) bool {
+	level := TLP(tags)
+	if level == "" {
+		return false
+	}
+
+	return TLPRank(level) > TLPRank(maxLevel)
+}
+
+// FilterTLP drops the indicators that are marked more restrictive than maxLevel.
+func FilterTLP(indicators []Indicator, maxLevel string) []Indicator {
+	filtered := make([]Indicator, 0, len(indicators))
+	for _, indicator := range indicators {
+		if !AboveTLP(indicator.Tags, maxLevel) {
+			filtered = append(filtered, indicator)
+		}
+	}
+
+	return filtered
+}
+
+func highestLevel(tags []string, prefix string, levels []string) string {
+	highest := -1
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+
+		if rank := levelRank(normalizeLevel(strings.TrimPrefix(tag, prefix)), levels); rank > highest {
+			highest = rank
+		}
+	}
+
+	if highest < 0 {
+		return ""
+	}
+
+	return levels[highest]
+}
+
+// normalizeLevel maps the TLP 1.0 white level onto its TLP 2.0 clear equivalent.
+func normalizeLevel(level string) string {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if level == "white" {
+		return "clear"
+	}
+
+	return level
+}
+
+func levelRank(level string, levels []string) int {
+	for i, known := range levels {
+		if known == level {
+			return i
+		}
+	}
+
+	return -1
+}
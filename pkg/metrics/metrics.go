@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const namespace = "mispsent"
+
+const (
+	// reasons MISP attributes and objects are not exported
+	FilteredType         = "type"
+	FilteredEmpty        = "empty"
+	FilteredObjectMember = "object_member"
+	FilteredTLP          = "tlp"
+	FilteredUnsupported  = "unsupported"
+	FilteredExpired      = "expired"
+	FilteredTimestamp    = "timestamp"
+
+	// reasons the Sentinel request rate is lowered
+	ThrottleRejected = "throttled"
+	ThrottleLowQuota = "low_quota"
+)
+
+// Registry holds the metrics of this process only, without the Go runtime collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	MISPPagesFetched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "misp_pages_fetched_total",
+		Help:      "MISP result pages fetched, by kind of result.",
+	}, []string{"kind"})
+
+	MISPRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "misp_request_retries_total",
+		Help:      "MISP requests that were retried, by status code or error when there was no response.",
+	}, []string{"reason"})
+
+	MISPAttributesFetched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "misp_attributes_fetched_total",
+		Help:      "MISP attributes and objects fetched, by kind.",
+	}, []string{"kind"})
+
+	MISPAttributesFiltered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "misp_attributes_filtered_total",
+		Help:      "MISP attributes and objects that were not exported, by reason.",
+	}, []string{"reason"})
+
+	Indicators = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sentinel_indicators_total",
+		Help:      "Sentinel indicators created, updated, skipped, revoked and deleted, by threat type.",
+	}, []string{"action", "threat_type"})
+
+	AzureRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "azure_request_duration_seconds",
+		Help:      "Latency of the Azure API requests, by HTTP method and status code.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"method", "code"})
+
+	AzureThrottleEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "azure_throttle_events_total",
+		Help:      "Times the Sentinel request rate was lowered, by reason.",
+	}, []string{"reason"})
+
+	LastSuccessfulSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Unix time of the last sync that pushed all changes into Sentinel.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		MISPPagesFetched, MISPRetries, MISPAttributesFetched, MISPAttributesFiltered,
+		Indicators, AzureRequestDuration, AzureThrottleEvents, LastSuccessfulSync,
+	)
+}
+
+// Serve exposes the metrics on /metrics until the context is cancelled.
+func Serve(ctx context.Context, address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("could not serve metrics on '%s': %v", address, err)
+	}
+
+	return nil
+}
+
+// WriteTextfile writes the metrics in the text format, for the node exporter textfile collector or a pushgateway.
+func WriteTextfile(path string) error {
+	if err := prometheus.WriteToTextfile(path, Registry); err != nil {
+		return fmt.Errorf("could not write metrics to '%s': %v", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package misp
+
+import (
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// prefix of the IDs of indicators for whole MISP objects, so they never clash with attribute IDs
+	objectIDPrefix = "object-"
+
+	mispLastSeenFormat = "2006-01-02T15:04:05.999999999Z07:00"
+)
+
+// attributeIndicator maps a MISP attribute onto a source-neutral indicator.
+func (m *MISP) attributeIndicator(attribute Attribute) indicator.Indicator {
+	logger := m.logger.WithField("attr_id", attribute.ID)
+
+	decayScores := make([]float64, 0, len(attribute.DecayScore))
+	for _, decayScore := range attribute.DecayScore {
+		decayScores = append(decayScores, decayScore.Score)
+	}
+
+	sightings, falsePositives := countSightings(attribute.Sighting)
+
+	// decay scores and sightings change on every fetch, they are only fingerprinted through the confidence
+	hashed := attribute
+	hashed.DecayScore = nil
+	hashed.Sighting = nil
+
+	return indicator.Indicator{
+		ID:   attribute.ID,
+		UUID: attribute.UUID,
+		Type: attribute.Type,
+		Observables: []indicator.Observable{
+			{Type: attribute.Type, Value: attribute.Value, Relation: attribute.Relation()},
+		},
+		Category:       attribute.Category,
+		Description:    attribute.Comment,
+		Deleted:        attribute.Deleted,
+		Modified:       parseTimestamp(logger, attribute.Timestamp),
+		LastSeen:       parseLastSeen(logger, attribute.LastSeen),
+		Group:          eventGroup(attribute.Event, attribute.EventID),
+		Tags:           attribute.TagNames(),
+		Clusters:       neutralClusters(attribute.GalaxyClusters()),
+		DecayScores:    decayScores,
+		Sightings:      sightings,
+		FalsePositives: falsePositives,
+		Hash:           indicator.ContentHash(hashed),
+	}
+}
+
+// objectIndicator maps a MISP object onto a single composite indicator over all of its attributes.
+func (m *MISP) objectIndicator(object Object) indicator.Indicator {
+	logger := m.logger.WithField("object_id", object.ID)
+
+	observables := make([]indicator.Observable, 0, len(object.Attribute))
+	sightings := make([]Sighting, 0)
+
+	hashed := object
+	hashed.Attribute = make([]Attribute, 0, len(object.Attribute))
+
+	for _, attribute := range object.Attribute {
+		observables = append(observables, indicator.Observable{
+			Type:     attribute.Type,
+			Value:    attribute.Value,
+			Relation: attribute.Relation(),
+		})
+
+		sightings = append(sightings, attribute.Sighting...)
+
+		attribute.DecayScore = nil
+		attribute.Sighting = nil
+		hashed.Attribute = append(hashed.Attribute, attribute)
+	}
+
+	numSightings, falsePositives := countSightings(sightings)
+
+	return indicator.Indicator{
+		ID:             objectIDPrefix + object.ID,
+		UUID:           object.UUID,
+		Composite:      true,
+		Type:           object.Name,
+		Category:       object.MetaCategory,
+		Observables:    observables,
+		Description:    object.Comment,
+		Deleted:        object.Deleted,
+		Modified:       parseTimestamp(logger, object.Timestamp),
+		LastSeen:       parseLastSeen(logger, object.LastSeen),
+		Group:          eventGroup(object.Event, object.EventID),
+		Tags:           object.TagNames(),
+		Clusters:       neutralClusters(object.GalaxyClusters()),
+		Sightings:      numSightings,
+		FalsePositives: falsePositives,
+		Hash:           indicator.ContentHash(hashed),
+	}
+}
+
+func eventGroup(event Event, eventID string) indicator.Group {
+	if event.ID != "" {
+		eventID = event.ID
+	}
+
+	return indicator.Group{
+		ID:    eventID,
+		UUID:  event.UUID,
+		Info:  event.Info,
+		Links: event.Links,
+	}
+}
+
+func neutralClusters(clusters []GalaxyCluster) []indicator.Cluster {
+	neutral := make([]indicator.Cluster, 0, len(clusters))
+	for _, cluster := range clusters {
+		neutral = append(neutral, indicator.Cluster{
+			Type:        cluster.Type,
+			Value:       cluster.Value,
+			ExternalIDs: cluster.MetaStrings("external_id"),
+			KillChain:   cluster.MetaStrings("kill_chain"),
+		})
+	}
+
+	return neutral
+}
+
+func countSightings(sightings []Sighting) (int, int) {
+	numSightings := 0
+	falsePositives := 0
+
+	for _, sighting := range sightings {
+		switch sighting.Type {
+		case SightingTypeSighting:
+			numSightings += 1
+		case SightingTypeFalsePositive:
+			falsePositives += 1
+		}
+	}
+
+	return numSightings, falsePositives
+}
+
+// parseTimestamp returns zero when the timestamp cannot be parsed, such indicators are left out as their modification time is unknown.
+func parseTimestamp(logger *logrus.Entry, rawTimestamp string) time.Time {
+	tsUnix, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		logger.WithError(err).WithField("ts", rawTimestamp).Warn("could not parse MISP timestamp, leaving the indicator out")
+		return time.Time{}
+	}
+
+	return time.Unix(tsUnix, 0)
+}
+
+func parseLastSeen(logger *logrus.Entry, rawLastSeen interface{}) time.Time {
+	lastSeen, _ := rawLastSeen.(string)
+	if strings.TrimSpace(lastSeen) == "" {
+		return time.Time{}
+	}
+
+	parsed, err := time.Parse(mispLastSeenFormat, lastSeen)
+	if err != nil {
+		logger.WithError(err).WithField("raw", lastSeen).Error("could not parse MISP last_seen")
+		return time.Time{}
+	}
+
+	return parsed
+}
@@ -0,0 +1,28 @@
+package misp
+
+import (
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want time.Time
+	}{
+		{raw: "1709251200", want: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{raw: "", want: time.Time{}},
+		{raw: "yesterday", want: time.Time{}},
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	for _, tt := range tests {
+		if got := parseTimestamp(logrus.NewEntry(logger), tt.raw); !got.Equal(tt.want) {
+			t.Errorf("parseTimestamp(%q) = %s, want %s", tt.raw, got, tt.want)
+		}
+	}
+}
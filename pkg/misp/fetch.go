@@ -2,48 +2,88 @@ package misp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/metrics"
 	"github.com/sirupsen/logrus"
 	"io"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
 	mispMaxAttributesPerFetch = 100
-	mispMaxFailures           = 5
+
+	// only this much of an error response is read for logging
+	mispMaxErrorBodySize = 4096
+
+	// kinds of results in the fetch metrics
+	kindAttributes = "attributes"
+	kindObjects    = "objects"
 )
 
 type Attribute struct {
-	ID                 string      `json:"id"`
-	EventID            string      `json:"event_id"`
-	ObjectID           string      `json:"object_id"`
-	ObjectRelation     interface{} `json:"object_relation"`
-	Category           string      `json:"category"`
-	Type               string      `json:"type"`
-	ToIds              bool        `json:"to_ids"`
-	UUID               string      `json:"uuid"`
-	Timestamp          string      `json:"timestamp"`
-	Distribution       string      `json:"distribution"`
-	SharingGroupID     string      `json:"sharing_group_id"`
-	Comment            string      `json:"comment"`
-	Deleted            bool        `json:"deleted"`
-	DisableCorrelation bool        `json:"disable_correlation"`
-	FirstSeen          interface{} `json:"first_seen"`
-	LastSeen           interface{} `json:"last_seen"`
-	Value              string      `json:"value"`
-	Event              struct {
-		OrgID        string `json:"org_id"`
-		Distribution string `json:"distribution"`
-		ID           string `json:"id"`
-		Info         string `json:"info"`
-		OrgcID       string `json:"orgc_id"`
-		UUID         string `json:"uuid"`
-	} `json:"Event"`
+	ID                 string       `json:"id"`
+	EventID            string       `json:"event_id"`
+	ObjectID           string       `json:"object_id"`
+	ObjectRelation     interface{}  `json:"object_relation"`
+	Category           string       `json:"category"`
+	Type               string       `json:"type"`
+	ToIds              bool         `json:"to_ids"`
+	UUID               string       `json:"uuid"`
+	Timestamp          string       `json:"timestamp"`
+	Distribution       string       `json:"distribution"`
+	SharingGroupID     string       `json:"sharing_group_id"`
+	Comment            string       `json:"comment"`
+	Deleted            bool         `json:"deleted"`
+	DisableCorrelation bool         `json:"disable_correlation"`
+	FirstSeen          interface{}  `json:"first_seen"`
+	LastSeen           interface{}  `json:"last_seen"`
+	Value              string       `json:"value"`
+	Warnings           []Warning    `json:"warnings,omitempty"`
+	Tag                []Tag        `json:"Tag,omitempty"`
+	DecayScore         []DecayScore `json:"decay_score,omitempty"`
+	Sighting           []Sighting   `json:"Sighting,omitempty"`
+	Galaxy             []Galaxy     `json:"Galaxy,omitempty"`
+	Event              Event        `json:"Event"`
+}
+
+type Event struct {
+	OrgID        string   `json:"org_id"`
+	Distribution string   `json:"distribution"`
+	ID           string   `json:"id"`
+	Info         string   `json:"info"`
+	OrgcID       string   `json:"orgc_id"`
+	UUID         string   `json:"uuid"`
+	Tag          []Tag    `json:"Tag,omitempty"`
+	Galaxy       []Galaxy `json:"Galaxy,omitempty"`
+	// Links are the link attributes of the event, set by AddEventLinks
+	Links []string `json:"links,omitempty"`
+}
+
+type Warning struct {
+	WarninglistID   string `json:"warninglist_id"`
+	WarninglistName string `json:"warninglist_name"`
+}
+
+type DecayScore struct {
+	Score   float64 `json:"score"`
+	Decayed bool    `json:"decayed"`
+}
+
+const (
+	SightingTypeSighting      = "0"
+	SightingTypeFalsePositive = "1"
+)
+
+type Sighting struct {
+	Type         string `json:"type"`
+	DateSighting string `json:"date_sighting"`
 }
 
 type Response struct {
@@ -52,145 +92,186 @@ type Response struct {
 	} `json:"response"`
 }
 
-func (m *MISP) FetchIndicators(daysToFetch uint32, typesToFetch []string) ([]Attribute, error) {
-	indicators := make([]Attribute, 0)
+type searchRequest struct {
+	Format string `json:"returnFormat"`
+	From   string `json:"last_seen,omitempty"`
+	// PublishTimestamp only returns results of events published since this unix timestamp
+	PublishTimestamp string   `json:"publish_timestamp,omitempty"`
+	UUIDs            []string `json:"uuid,omitempty"`
+	EventIDs         []string `json:"eventid,omitempty"`
+	Types            []string `json:"type,omitempty"`
+
+	ObjectNames []string `json:"object_name,omitempty"`
+
+	SkipFalsePositives     bool        `json:"enforceWarninglist"`
+	IncludeWarninglistHits bool        `json:"includeWarninglistHits,omitempty"`
+	IncludeEventTags       bool        `json:"includeEventTags,omitempty"`
+	IncludeDecayScore      bool        `json:"includeDecayScore,omitempty"`
+	IncludeSightings       bool        `json:"includeSightings,omitempty"`
+	IncludeGalaxy          bool        `json:"includeGalaxy,omitempty"`
+	ExcludeDecayed         bool        `json:"excludeDecayed"`
+	Published              bool        `json:"published,omitempty"`
+	ToIDs                  bool        `json:"to_ids,omitempty"`
+	Deleted                interface{} `json:"deleted"`
+
+	Page  int32 `json:"page"`
+	Limit int32 `json:"limit"`
+}
 
-	if daysToFetch == 0 {
-		return nil, errors.New("cannot fetch 0 days")
-	}
+// restSearch pages through the attributes matching the search and calls fn for every page.
+func (m *MISP) restSearch(ctx context.Context, search searchRequest, fn func([]Attribute) error) error {
+	return m.search(ctx, "/attributes/restSearch", search, func(body io.Reader) (int, error) {
+		var response Response
+		if err := json.NewDecoder(body).Decode(&response); err != nil {
+			return 0, fmt.Errorf("could not decode response: %v", err)
+		}
 
-	httpClient := http.Client{Timeout: time.Minute * 15}
+		if len(response.Response.Attribute) == 0 {
+			return 0, nil
+		}
+
+		return len(response.Response.Attribute), fn(response.Response.Attribute)
+	})
+}
 
-	url := strings.TrimSuffix(m.baseURL, "/") + "/attributes/restSearch"
-	fromTime := time.Now().AddDate(0, 0, -1*int(daysToFetch))
-	fromTimeStr := fromTime.Format("2006-01-02")
+// search pages through a MISP restSearch endpoint and calls fn with every response body, so pages are decoded while they stream in.
+// fn returns the amount of results in the page, paging stops at the first empty page or when the context is done.
+func (m *MISP) search(ctx context.Context, path string, search searchRequest, fn func(io.Reader) (int, error)) error {
+	url := strings.TrimSuffix(m.baseURL, "/") + path
 
 	page := int32(0)
 	limit := mispMaxAttributesPerFetch
-	submitted := 0
-	mispFailures := 0
 
 	for {
-		body := struct {
-			Format string `json:"returnFormat"`
-			From   string `json:"last_seen"`
-
-			SkipFalsePositives bool `json:"enforceWarninglist"`
-			ExcludeDecayed     bool `json:"excludeDecayed"`
-			Published          bool `json:"published"`
-			Deleted            bool `json:"deleted"`
-
-			Page  int32 `json:"page"`
-			Limit int32 `json:"limit"`
-		}{
-			Format: "json",
-			From:   fromTimeStr,
-
-			SkipFalsePositives: true,
-			ExcludeDecayed:     true,
-			Published:          true,
-			Deleted:            false,
-
-			Page:  page,
-			Limit: int32(limit),
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		bodyBytes, err := json.Marshal(&body)
-		if err != nil {
-			return nil, fmt.Errorf("could not encode body: %v", err)
-		}
+		search.Format = "json"
+		search.Page = page
+		search.Limit = int32(limit)
 
-		httpRequest, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(bodyBytes))
+		bodyBytes, err := json.Marshal(&search)
 		if err != nil {
-			return nil, fmt.Errorf("could not create http request: %v", err)
+			return fmt.Errorf("could not encode body: %v", err)
 		}
 
-		httpRequest.Header.Set("accept", "application/json")
-		httpRequest.Header.Set("content-type", "application/json")
-		httpRequest.Header.Set("authorization", m.accessKey)
+		m.logger.WithField("page", page).WithField("limit", limit).
+			Debug("fetching MISP attributes")
 
-		if m.logger.IsLevelEnabled(logrus.TraceLevel) {
-			reqBytes, err := httputil.DumpRequest(httpRequest, true)
-			if err != nil {
-				m.logger.WithError(err).Warn("could not dump http request")
-			}
+		results := 0
 
-			m.logger.Trace(string(reqBytes))
+		if err := m.do(ctx, url, bodyBytes, func(body io.Reader) error {
+			var err error
+			results, err = fn(body)
+			return err
+		}); err != nil {
+			return err
 		}
 
-		m.logger.WithField("page", page).WithField("limit", limit).
-			WithField("fetched", len(indicators)).
-			WithField("from", fromTimeStr).
-			Debug("fetching MISP indicators")
+		if results > mispMaxAttributesPerFetch {
+			m.logger.WithField("size", results).
+				Warn("MISP returned more results than expected")
+		}
 
-	retry:
-		resp, err := httpClient.Do(httpRequest)
-		if err != nil {
-			m.logger.Errorf("could not request: %v", err)
+		// break the for/while loop if necessary
+		if results == 0 {
+			m.logger.WithField("pages", page).Debug("received all MISP results, breaking")
+			break
+		}
 
-			mispFailures += 1
+		page += 1
+	}
 
-			// sometimes MISP will return errors, so retry up to X times
-			if mispFailures <= mispMaxFailures {
-				m.logger.WithField("status_code", resp.StatusCode).
-					WithField("tries", mispFailures).WithField("max_tries", mispMaxFailures).
-					Error("MISP failed response, retrying in 3 sec")
-				time.Sleep(time.Second * 3)
-				goto retry
-			}
+	return nil
+}
 
-			return nil, fmt.Errorf("could not request: %v", err)
-		}
+// post sends a search request with a fresh body, bound to the given context.
+func (m *MISP) post(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("could not create http request: %v", err)
+	}
 
-		m.logger.Debug("got misp response")
+	httpRequest.Header.Set("accept", "application/json")
+	httpRequest.Header.Set("content-type", "application/json")
+	httpRequest.Header.Set("authorization", m.accessKey)
 
-		respBytes, err := io.ReadAll(resp.Body)
+	if m.logger.IsLevelEnabled(logrus.TraceLevel) {
+		reqBytes, err := httputil.DumpRequest(httpRequest, true)
 		if err != nil {
-			_ = resp.Body.Close()
-			return nil, fmt.Errorf("could not read response: %v", err)
+			m.logger.WithError(err).Warn("could not dump http request")
 		}
 
-		_ = resp.Body.Close()
+		m.logger.Trace(string(reqBytes))
+	}
 
-		if resp.StatusCode > 399 {
-			m.logger.Debugf("%s", string(respBytes))
+	return m.httpClient.Do(httpRequest)
+}
 
-			mispFailures += 1
+// sleep waits for the given duration, unless the context is done first.
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
 
-			// sometimes MISP will return errors, so retry up to X times
-			if mispFailures <= mispMaxFailures {
-				m.logger.WithField("status_code", resp.StatusCode).
-					WithField("tries", mispFailures).WithField("max_tries", mispMaxFailures).
-					Error("MISP failed response, retrying in 3 sec")
-				time.Sleep(time.Second * 3)
-				goto retry
-			}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
 
-			// if all else fails, stop the program
-			return nil, fmt.Errorf("invalid response code: %d (tries %d/%d)", resp.StatusCode, mispFailures, mispMaxFailures)
-		}
+// setWindow limits the search to results of events published since the given time,
+// or to those seen in the last daysToFetch days when since is zero.
+// The publish time is used instead of the attribute timestamp, since only published events are fetched and
+// an attribute that was changed before the last sync may only be published after it.
+func setWindow(search *searchRequest, daysToFetch uint32, since time.Time) error {
+	if !since.IsZero() {
+		search.PublishTimestamp = strconv.FormatInt(since.Unix(), 10)
+		return nil
+	}
 
-		// reset failure count back to zero if one call succeeds
-		mispFailures = 0
+	if daysToFetch == 0 {
+		return errors.New("cannot fetch 0 days")
+	}
 
-		var response Response
-		if err := json.Unmarshal(respBytes, &response); err != nil {
-			return nil, fmt.Errorf("could not decode response: %v", err)
-		}
+	search.From = time.Now().AddDate(0, 0, -1*int(daysToFetch)).Format("2006-01-02")
+	return nil
+}
 
-		if len(response.Response.Attribute) > mispMaxAttributesPerFetch {
-			m.logger.WithField("size", len(response.Response.Attribute)).
-				Warn("MISP returned more results than expected")
-		}
+// FetchIndicators fetches the attributes of events published since the given time, or seen in the last daysToFetch days when since is zero.
+// Attributes are passed to fn page by page as they are fetched, so the window size does not affect memory use.
+func (m *MISP) FetchIndicators(ctx context.Context, daysToFetch uint32, since time.Time, typesToFetch []string, fn func([]Attribute) error) error {
+	fetched := 0
+	submitted := 0
 
-		// break the for/while loop if necessary
-		if len(response.Response.Attribute) == 0 {
-			m.logger.WithField("attributes", len(indicators)).
-				Debug("received all MISP attributes, breaking")
-			break
-		}
+	search := searchRequest{
+		SkipFalsePositives: true,
+		IncludeEventTags:   true,
+		IncludeDecayScore:  true,
+		IncludeSightings:   true,
+		IncludeGalaxy:      true,
+		ExcludeDecayed:     true,
+		Published:          true,
+		ToIDs:              true,
+		Deleted:            false,
+	}
 
-		for _, attribute := range response.Response.Attribute {
+	if err := setWindow(&search, daysToFetch, since); err != nil {
+		return err
+	}
+
+	m.logger.WithField("from", search.From).WithField("since", search.PublishTimestamp).Debug("fetching MISP indicators")
+
+	err := m.restSearch(ctx, search, func(attributes []Attribute) error {
+		indicators := make([]Attribute, 0, len(attributes))
+		fetched += len(attributes)
+
+		metrics.MISPPagesFetched.WithLabelValues(kindAttributes).Inc()
+		metrics.MISPAttributesFetched.WithLabelValues(kindAttributes).Add(float64(len(attributes)))
+
+		for _, attribute := range attributes {
 
 			attLogger := m.logger.WithField("attribute", attribute.ID).WithField("type", attribute.Type)
 
@@ -204,6 +285,7 @@ func (m *MISP) FetchIndicators(daysToFetch uint32, typesToFetch []string) ([]Att
 
 			if !allowedType {
 				attLogger.Debug("skipping attribute because of type")
+				metrics.MISPAttributesFiltered.WithLabelValues(metrics.FilteredType).Inc()
 				continue
 			}
 
@@ -221,11 +303,18 @@ func (m *MISP) FetchIndicators(daysToFetch uint32, typesToFetch []string) ([]Att
 			submitted += 1
 		}
 
-		page += 1
+		if len(indicators) == 0 {
+			return nil
+		}
+
+		return fn(indicators)
+	})
+	if err != nil {
+		return err
 	}
 
-	m.logger.WithField("fetched", len(indicators)).WithField("submitted", submitted).
+	m.logger.WithField("fetched", fetched).WithField("submitted", submitted).
 		Debug("fetched MISP indicators")
 
-	return indicators, nil
+	return nil
 }
@@ -0,0 +1,113 @@
+package misp
+
+import (
+	"strings"
+)
+
+const galaxyTagPrefix = "misp-galaxy:"
+
+type Galaxy struct {
+	Type          string          `json:"type"`
+	Name          string          `json:"name"`
+	GalaxyCluster []GalaxyCluster `json:"GalaxyCluster"`
+}
+
+type GalaxyCluster struct {
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	TagName string `json:"tag_name"`
+	// Meta differs per galaxy, values are mostly lists of strings
+	Meta map[string]interface{} `json:"meta,omitempty"`
+}
+
+// MetaStrings returns the cluster meta values under key, accepting both a single string and a list.
+func (c GalaxyCluster) MetaStrings(key string) []string {
+	switch value := c.Meta[key].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// GalaxyClusters returns the galaxy clusters of the attribute and its event.
+// Clusters that are only known as misp-galaxy tags are included without meta.
+func (a Attribute) GalaxyClusters() []GalaxyCluster {
+	galaxies := append(append([]Galaxy{}, a.Galaxy...), a.Event.Galaxy...)
+	return galaxyClusters(galaxies, a.TagNames())
+}
+
+// GalaxyClusters returns the galaxy clusters of the object event and of all object attributes.
+func (o Object) GalaxyClusters() []GalaxyCluster {
+	galaxies := append([]Galaxy{}, o.Event.Galaxy...)
+	for _, attribute := range o.Attribute {
+		galaxies = append(galaxies, attribute.Galaxy...)
+	}
+
+	return galaxyClusters(galaxies, o.TagNames())
+}
+
+func galaxyClusters(galaxies []Galaxy, tags []string) []GalaxyCluster {
+	clusters := make([]GalaxyCluster, 0)
+	seen := make(map[string]bool)
+
+	for _, galaxy := range galaxies {
+		for _, cluster := range galaxy.GalaxyCluster {
+			if cluster.Type == "" {
+				cluster.Type = galaxy.Type
+			}
+
+			key := cluster.Type + "=" + cluster.Value
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			clusters = append(clusters, cluster)
+		}
+	}
+
+	for _, tag := range tags {
+		cluster, ok := parseGalaxyTag(tag)
+		if !ok {
+			continue
+		}
+
+		key := cluster.Type + "=" + cluster.Value
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters
+}
+
+// parseGalaxyTag parses a misp-galaxy:<type>="<value>" tag.
+func parseGalaxyTag(tag string) (GalaxyCluster, bool) {
+	if !strings.HasPrefix(tag, galaxyTagPrefix) {
+		return GalaxyCluster{}, false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(tag, galaxyTagPrefix), "=", 2)
+	if len(parts) != 2 {
+		return GalaxyCluster{}, false
+	}
+
+	value := strings.Trim(parts[1], "\"")
+	if parts[0] == "" || value == "" {
+		return GalaxyCluster{}, false
+	}
+
+	return GalaxyCluster{Type: parts[0], Value: value, TagName: tag}, true
+}
@@ -0,0 +1,129 @@
+package misp
+
+import (
+	"context"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"sort"
+)
+
+const (
+	// amount of event IDs to look up links for per search
+	mispLinkBatchSize = 100
+
+	linkAttributeType = "link"
+)
+
+// FetchEventLinks returns the values of the link attributes in the given events, keyed by event ID.
+// Links are filtered like the attributes that are exported, so a link never leaks above maxTLP or from an unpublished event.
+func (m *MISP) FetchEventLinks(ctx context.Context, eventIDs []string, maxTLP string) (map[string][]string, error) {
+	links := make(map[string][]string)
+
+	for start := 0; start < len(eventIDs); start += mispLinkBatchSize {
+		end := start + mispLinkBatchSize
+		if end > len(eventIDs) {
+			end = len(eventIDs)
+		}
+
+		if err := m.restSearch(ctx, searchRequest{
+			EventIDs:           eventIDs[start:end],
+			Types:              []string{linkAttributeType},
+			SkipFalsePositives: true,
+			IncludeEventTags:   true,
+			Published:          true,
+			ToIDs:              true,
+			Deleted:            false,
+		}, func(attributes []Attribute) error {
+			for _, attribute := range attributes {
+				if indicator.AboveTLP(attribute.TagNames(), maxTLP) {
+					continue
+				}
+
+				links[attribute.EventID] = append(links[attribute.EventID], attribute.Value)
+			}
+
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	for eventID := range links {
+		sort.Strings(links[eventID])
+	}
+
+	m.logger.WithField("events", len(eventIDs)).WithField("with_links", len(links)).
+		Debug("fetched MISP event links")
+
+	return links, nil
+}
+
+// eventLinks caches the event links of a single fetch, so events that span several pages are looked up once.
+type eventLinks struct {
+	client *MISP
+	maxTLP string
+	links  map[string][]string
+}
+
+func newEventLinks(client *MISP, maxTLP string) *eventLinks {
+	return &eventLinks{client: client, maxTLP: maxTLP, links: make(map[string][]string)}
+}
+
+// add sets the links of their event on the attributes and objects, only the events that were not seen before are looked up.
+func (e *eventLinks) add(ctx context.Context, attributes []Attribute, objects []Object) error {
+	missing := make([]string, 0)
+	for _, eventID := range EventIDs(attributes, objects) {
+		if _, ok := e.links[eventID]; !ok {
+			missing = append(missing, eventID)
+		}
+	}
+
+	if len(missing) > 0 {
+		fetched, err := e.client.FetchEventLinks(ctx, missing, e.maxTLP)
+		if err != nil {
+			return err
+		}
+
+		// events without links are cached too, so they are not looked up again
+		for _, eventID := range missing {
+			e.links[eventID] = fetched[eventID]
+		}
+	}
+
+	AddEventLinks(attributes, objects, e.links)
+	return nil
+}
+
+// EventIDs returns the distinct event IDs of the attributes and objects.
+func EventIDs(attributes []Attribute, objects []Object) []string {
+	ids := make([]string, 0)
+	seen := make(map[string]bool)
+
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	for _, attribute := range attributes {
+		add(attribute.EventID)
+	}
+
+	for _, object := range objects {
+		add(object.EventID)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+// AddEventLinks sets the links of their event on the attributes and objects.
+func AddEventLinks(attributes []Attribute, objects []Object, links map[string][]string) {
+	for i := range attributes {
+		attributes[i].Event.Links = links[attributes[i].EventID]
+	}
+
+	for i := range objects {
+		objects[i].Event.Links = links[objects[i].EventID]
+	}
+}
@@ -0,0 +1,84 @@
+package misp
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFetchEventLinks(t *testing.T) {
+	attributes := []Attribute{
+		{EventID: "1", Value: "https://example.com/clear", Tag: []Tag{{Name: "tlp:clear"}}},
+		{EventID: "1", Value: "https://example.com/red", Tag: []Tag{{Name: "tlp:red"}}},
+		{EventID: "2", Value: "https://example.com/untagged"},
+		{EventID: "3", Value: "https://example.com/red-event", Event: Event{Tag: []Tag{{Name: "tlp:red"}}}},
+	}
+
+	tests := []struct {
+		name   string
+		maxTLP string
+		want   map[string][]string
+	}{
+		{
+			name:   "amber leaves out red links and red events",
+			maxTLP: "amber",
+			want:   map[string][]string{"1": {"https://example.com/clear"}, "2": {"https://example.com/untagged"}},
+		},
+		{
+			name:   "red keeps every link",
+			maxTLP: "red",
+			want: map[string][]string{
+				"1": {"https://example.com/clear", "https://example.com/red"},
+				"2": {"https://example.com/untagged"},
+				"3": {"https://example.com/red-event"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var search searchRequest
+			requests := 0
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewDecoder(r.Body).Decode(&search)
+
+				var response Response
+				if requests == 0 {
+					response.Response.Attribute = attributes
+				}
+				requests++
+
+				_ = json.NewEncoder(w).Encode(response)
+			}))
+			defer server.Close()
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			client, err := New(logger, server.URL, "key", Options{RequestTimeout: 5 * time.Second})
+			if err != nil {
+				t.Fatalf("could not create client: %v", err)
+			}
+
+			links, err := client.FetchEventLinks(context.Background(), []string{"1", "2", "3"}, tt.maxTLP)
+			if err != nil {
+				t.Fatalf("FetchEventLinks() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(links, tt.want) {
+				t.Errorf("FetchEventLinks() = %v, want %v", links, tt.want)
+			}
+
+			if !search.Published || !search.ToIDs || !search.SkipFalsePositives || !search.IncludeEventTags {
+				t.Errorf("FetchEventLinks() searched %+v, want published IDS links with their event tags", search)
+			}
+		})
+	}
+}
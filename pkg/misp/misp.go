@@ -3,15 +3,32 @@ package misp
 import (
 	"errors"
 	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
 )
 
+const (
+	// defaultRequestTimeout bounds a single MISP request, including reading its response
+	defaultRequestTimeout = time.Minute * 15
+)
+
+// Options tune how the MISP client talks to MISP.
+type Options struct {
+	// RequestTimeout bounds every single request, whole runs are bounded through their context
+	RequestTimeout time.Duration
+	Retry          RetryPolicy
+}
+
 type MISP struct {
-	logger    *logrus.Logger
-	baseURL   string
-	accessKey string
+	logger     *logrus.Logger
+	baseURL    string
+	accessKey  string
+	opts       Options
+	httpClient *http.Client
+	jitter     *jitter
 }
 
-func New(l *logrus.Logger, baseURL, accessKey string) (*MISP, error) {
+func New(l *logrus.Logger, baseURL, accessKey string, opts Options) (*MISP, error) {
 	if baseURL == "" {
 		return nil, errors.New("no base url provided")
 	}
@@ -20,10 +37,20 @@ func New(l *logrus.Logger, baseURL, accessKey string) (*MISP, error) {
 		return nil, errors.New("no access key provided")
 	}
 
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = defaultRequestTimeout
+	}
+
+	opts.Retry.setDefaults()
+
 	misp := MISP{
 		logger:    l,
 		baseURL:   baseURL,
 		accessKey: accessKey,
+		opts:      opts,
+		// requests are bounded by their context, so the connections are reused across the whole run
+		httpClient: &http.Client{},
+		jitter:     newJitter(),
 	}
 
 	return &misp, nil
@@ -0,0 +1,137 @@
+package misp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/metrics"
+	"io"
+	"time"
+)
+
+type Object struct {
+	ID              string      `json:"id"`
+	Name            string      `json:"name"`
+	MetaCategory    string      `json:"meta-category"`
+	Description     string      `json:"description"`
+	TemplateUUID    string      `json:"template_uuid"`
+	TemplateVersion string      `json:"template_version"`
+	EventID         string      `json:"event_id"`
+	UUID            string      `json:"uuid"`
+	Timestamp       string      `json:"timestamp"`
+	Distribution    string      `json:"distribution"`
+	SharingGroupID  string      `json:"sharing_group_id"`
+	Comment         string      `json:"comment"`
+	Deleted         bool        `json:"deleted"`
+	FirstSeen       interface{} `json:"first_seen"`
+	LastSeen        interface{} `json:"last_seen"`
+	Event           Event       `json:"Event"`
+	Attribute       []Attribute `json:"Attribute"`
+}
+
+type ObjectResponse struct {
+	Response []struct {
+		Object Object `json:"Object"`
+	} `json:"response"`
+}
+
+// Relation returns the object relation of an attribute that is part of a MISP object.
+func (a Attribute) Relation() string {
+	relation, _ := a.ObjectRelation.(string)
+	return relation
+}
+
+// objectSearch pages through the objects matching the search and calls fn for every page.
+func (m *MISP) objectSearch(ctx context.Context, search searchRequest, fn func([]Object) error) error {
+	return m.search(ctx, "/objects/restSearch", search, func(body io.Reader) (int, error) {
+		var response ObjectResponse
+		if err := json.NewDecoder(body).Decode(&response); err != nil {
+			return 0, fmt.Errorf("could not decode object response: %v", err)
+		}
+
+		if len(response.Response) == 0 {
+			return 0, nil
+		}
+
+		objects := make([]Object, 0, len(response.Response))
+		for _, entry := range response.Response {
+			objects = append(objects, entry.Object)
+		}
+
+		return len(objects), fn(objects)
+	})
+}
+
+// exportableObjects counts a fetched page of objects and drops their deleted attributes, and the objects left without any.
+func (m *MISP) exportableObjects(page []Object) []Object {
+	objects := make([]Object, 0, len(page))
+
+	metrics.MISPPagesFetched.WithLabelValues(kindObjects).Inc()
+	metrics.MISPAttributesFetched.WithLabelValues(kindObjects).Add(float64(len(page)))
+
+	for _, object := range page {
+		members := make([]Attribute, 0, len(object.Attribute))
+		for _, attribute := range object.Attribute {
+			if !attribute.Deleted {
+				members = append(members, attribute)
+			}
+		}
+
+		if len(members) == 0 {
+			m.logger.WithField("object", object.ID).Debug("skipping object without attributes")
+			metrics.MISPAttributesFiltered.WithLabelValues(metrics.FilteredEmpty).Inc()
+			continue
+		}
+
+		object.Attribute = members
+		objects = append(objects, object)
+	}
+
+	return objects
+}
+
+// FetchObjects fetches the MISP objects of the given names, with their attributes, so they can be exported as one indicator each.
+// Like FetchIndicators, only objects of events published since the given time are fetched unless since is zero, and they are passed to fn page by page.
+func (m *MISP) FetchObjects(ctx context.Context, daysToFetch uint32, since time.Time, objectNames []string, fn func([]Object) error) error {
+	if len(objectNames) == 0 {
+		return nil
+	}
+
+	fetched := 0
+
+	search := searchRequest{
+		ObjectNames: objectNames,
+
+		SkipFalsePositives: true,
+		IncludeEventTags:   true,
+		IncludeGalaxy:      true,
+		ExcludeDecayed:     true,
+		Published:          true,
+		Deleted:            false,
+	}
+
+	if err := setWindow(&search, daysToFetch, since); err != nil {
+		return err
+	}
+
+	m.logger.WithField("from", search.From).WithField("since", search.PublishTimestamp).
+		WithField("names", objectNames).Debug("fetching MISP objects")
+
+	err := m.objectSearch(ctx, search, func(page []Object) error {
+		objects := m.exportableObjects(page)
+
+		if len(objects) == 0 {
+			return nil
+		}
+
+		fetched += len(objects)
+		return fn(objects)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.WithField("fetched", fetched).Debug("fetched MISP objects")
+
+	return nil
+}
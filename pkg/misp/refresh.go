@@ -0,0 +1,70 @@
+package misp
+
+import (
+	"context"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/metrics"
+)
+
+// FetchCurrentAttributes fetches the exported attributes of the UUIDs that are still published and IDS, with their
+// current decay scores and sightings, and passes them to fn page by page. Decayed attributes are included so their
+// confidence can drop.
+func (m *MISP) FetchCurrentAttributes(ctx context.Context, uuids []string, fn func([]Attribute) error) error {
+	for start := 0; start < len(uuids); start += mispLookupBatchSize {
+		end := start + mispLookupBatchSize
+		if end > len(uuids) {
+			end = len(uuids)
+		}
+
+		if err := m.restSearch(ctx, searchRequest{
+			UUIDs:              uuids[start:end],
+			SkipFalsePositives: true,
+			IncludeEventTags:   true,
+			IncludeDecayScore:  true,
+			IncludeSightings:   true,
+			IncludeGalaxy:      true,
+			Published:          true,
+			ToIDs:              true,
+			Deleted:            false,
+		}, func(attributes []Attribute) error {
+			metrics.MISPPagesFetched.WithLabelValues(kindAttributes).Inc()
+			metrics.MISPAttributesFetched.WithLabelValues(kindAttributes).Add(float64(len(attributes)))
+
+			return fn(attributes)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FetchCurrentObjects fetches the exported objects of the UUIDs that are still published, with the current sightings
+// of their attributes, and passes them to fn page by page.
+func (m *MISP) FetchCurrentObjects(ctx context.Context, uuids []string, fn func([]Object) error) error {
+	for start := 0; start < len(uuids); start += mispLookupBatchSize {
+		end := start + mispLookupBatchSize
+		if end > len(uuids) {
+			end = len(uuids)
+		}
+
+		if err := m.objectSearch(ctx, searchRequest{
+			UUIDs:            uuids[start:end],
+			IncludeEventTags: true,
+			IncludeSightings: true,
+			IncludeGalaxy:    true,
+			Published:        true,
+			Deleted:          false,
+		}, func(page []Object) error {
+			objects := m.exportableObjects(page)
+			if len(objects) == 0 {
+				return nil
+			}
+
+			return fn(objects)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
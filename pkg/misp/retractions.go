@@ -0,0 +1,135 @@
+package misp
+
+import (
+	"context"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+)
+
+const (
+	// amount of attribute or object UUIDs to look up per search
+	mispLookupBatchSize = 100
+)
+
+// Retraction describes why a previously exported attribute should no longer be active.
+type Retraction struct {
+	Reason string
+	// Attribute is set for retracted attributes that still exist in MISP.
+	Attribute *Attribute
+	// Object is set for retracted objects that still exist in MISP.
+	Object *Object
+}
+
+// FetchRetractions looks up previously exported attributes and returns those that were deleted,
+// had to_ids disabled, now hit a warninglist or are now marked above maxTLP, keyed by attribute UUID.
+func (m *MISP) FetchRetractions(ctx context.Context, uuids []string, maxTLP string) (map[string]Retraction, error) {
+	retractions := make(map[string]Retraction)
+
+	for start := 0; start < len(uuids); start += mispLookupBatchSize {
+		end := start + mispLookupBatchSize
+		if end > len(uuids) {
+			end = len(uuids)
+		}
+
+		batch := uuids[start:end]
+		found := make(map[string]bool, len(batch))
+
+		if err := m.restSearch(ctx, searchRequest{
+			UUIDs:                  batch,
+			IncludeWarninglistHits: true,
+			IncludeEventTags:       true,
+			// include soft-deleted attributes so we can tell them apart from removed ones
+			Deleted: []int{0, 1},
+		}, func(attributes []Attribute) error {
+			for i := range attributes {
+				attribute := attributes[i]
+				found[attribute.UUID] = true
+
+				reason := retractionReason(attribute, maxTLP)
+				if reason == "" {
+					continue
+				}
+
+				retractions[attribute.UUID] = Retraction{Reason: reason, Attribute: &attribute}
+			}
+
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+
+		for _, uuid := range batch {
+			if !found[uuid] {
+				retractions[uuid] = Retraction{Reason: indicator.RetractionRemoved}
+			}
+		}
+	}
+
+	m.logger.WithField("checked", len(uuids)).WithField("retracted", len(retractions)).
+		Debug("checked MISP attributes for retractions")
+
+	return retractions, nil
+}
+
+// FetchObjectRetractions looks up previously exported objects and returns those that were deleted,
+// removed or are now marked above maxTLP, keyed by object UUID.
+func (m *MISP) FetchObjectRetractions(ctx context.Context, uuids []string, maxTLP string) (map[string]Retraction, error) {
+	retractions := make(map[string]Retraction)
+
+	for start := 0; start < len(uuids); start += mispLookupBatchSize {
+		end := start + mispLookupBatchSize
+		if end > len(uuids) {
+			end = len(uuids)
+		}
+
+		batch := uuids[start:end]
+		found := make(map[string]bool, len(batch))
+
+		if err := m.objectSearch(ctx, searchRequest{
+			UUIDs:            batch,
+			IncludeEventTags: true,
+			Deleted:          []int{0, 1},
+		}, func(objects []Object) error {
+			for i := range objects {
+				object := objects[i]
+				found[object.UUID] = true
+
+				switch {
+				case object.Deleted:
+					retractions[object.UUID] = Retraction{Reason: indicator.RetractionDeleted, Object: &object}
+				case indicator.AboveTLP(object.TagNames(), maxTLP):
+					retractions[object.UUID] = Retraction{Reason: indicator.RetractionTLP, Object: &object}
+				}
+			}
+
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+
+		for _, uuid := range batch {
+			if !found[uuid] {
+				retractions[uuid] = Retraction{Reason: indicator.RetractionRemoved}
+			}
+		}
+	}
+
+	m.logger.WithField("checked", len(uuids)).WithField("retracted", len(retractions)).
+		Debug("checked MISP objects for retractions")
+
+	return retractions, nil
+}
+
+func retractionReason(attribute Attribute, maxTLP string) string {
+	switch {
+	case attribute.Deleted:
+		return indicator.RetractionDeleted
+	case !attribute.ToIds:
+		return indicator.RetractionNotIDS
+	case len(attribute.Warnings) > 0:
+		return indicator.RetractionWarninglist
+	case indicator.AboveTLP(attribute.TagNames(), maxTLP):
+		return indicator.RetractionTLP
+	default:
+		return ""
+	}
+}
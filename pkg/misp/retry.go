@@ -0,0 +1,199 @@
+package misp
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/metrics"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxRetries     = 5
+	defaultRetryBaseDelay = time.Second
+	defaultRetryMaxDelay  = time.Minute
+
+	// a Retry-After beyond this is not waited for in full, the run deadline still applies on top
+	mispMaxRetryAfter = 10 * time.Minute
+
+	// retry metric reason for requests that got no response at all
+	retryReasonError = "error"
+)
+
+// RetryPolicy decides how often and how long to wait before failed MISP requests are retried.
+type RetryPolicy struct {
+	MaxRetries int
+	// BaseDelay doubles with every retry up to MaxDelay, a random jitter spreads the retries of concurrent runs
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func (p *RetryPolicy) setDefaults() {
+	// zero takes the default, a negative amount disables retries
+	if p.MaxRetries == 0 {
+		p.MaxRetries = defaultMaxRetries
+	} else if p.MaxRetries < 0 {
+		p.MaxRetries = 0
+	}
+
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = defaultRetryBaseDelay
+	}
+
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultRetryMaxDelay
+	}
+
+	if p.MaxDelay < p.BaseDelay {
+		p.MaxDelay = p.BaseDelay
+	}
+}
+
+// backoff returns the delay before the given retry, counting from zero, between half and all of the exponential delay.
+func (p RetryPolicy) backoff(retry int, random func(int64) int64) time.Duration {
+	delay := p.MaxDelay
+	if retry < 32 {
+		if exp := p.BaseDelay << uint(retry); exp > 0 && exp < p.MaxDelay {
+			delay = exp
+		}
+	}
+
+	half := delay / 2
+	return half + time.Duration(random(int64(delay-half)+1))
+}
+
+// retryableStatus returns whether a request that failed with the status code may succeed when it is sent again.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	default:
+		return code >= 500
+	}
+}
+
+// retryAfter returns how long MISP asked to wait in its Retry-After header, or zero.
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		wait = time.Until(at)
+	}
+
+	if wait < 0 {
+		return 0
+	}
+
+	if wait > mispMaxRetryAfter {
+		return mispMaxRetryAfter
+	}
+
+	return wait
+}
+
+// jitter draws the random part of the retry delays, seeded per process so concurrent runs spread out.
+type jitter struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newJitter() *jitter {
+	return &jitter{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (j *jitter) int63n(n int64) int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.rand.Int63n(n)
+}
+
+// do posts the body and passes a successful response to handle, failed requests are retried according to the retry policy.
+// Every attempt sends a fresh body and gets its own request deadline, which also covers handling the response.
+func (m *MISP) do(ctx context.Context, url string, body []byte, handle func(io.Reader) error) error {
+	for retry := 0; ; retry++ {
+		reqCtx, cancel := context.WithTimeout(ctx, m.opts.RequestTimeout)
+
+		resp, err := m.post(reqCtx, url, body)
+		if err != nil {
+			cancel()
+
+			// a cancelled run or an expired run deadline is not retried
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if retry >= m.opts.Retry.MaxRetries {
+				return fmt.Errorf("could not request: %v (tries %d)", err, retry+1)
+			}
+
+			if err := m.waitRetry(ctx, retry, retryReasonError, 0, err); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if resp.StatusCode > 399 {
+			respBytes, _ := io.ReadAll(io.LimitReader(resp.Body, mispMaxErrorBodySize))
+			_ = resp.Body.Close()
+			cancel()
+
+			m.logger.WithField("status_code", resp.StatusCode).Debugf("%s", string(respBytes))
+
+			statusErr := fmt.Errorf("invalid response code: %d", resp.StatusCode)
+
+			if !retryableStatus(resp.StatusCode) {
+				return statusErr
+			}
+
+			if retry >= m.opts.Retry.MaxRetries {
+				return fmt.Errorf("%v (tries %d)", statusErr, retry+1)
+			}
+
+			if err := m.waitRetry(ctx, retry, strconv.Itoa(resp.StatusCode), retryAfter(resp.Header), statusErr); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		m.logger.Debug("got misp response")
+
+		err = handle(resp.Body)
+
+		// drain what the decoder left so the connection can be reused
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		cancel()
+
+		return err
+	}
+}
+
+// waitRetry waits before the given retry, for at least as long as MISP asked.
+func (m *MISP) waitRetry(ctx context.Context, retry int, reason string, minDelay time.Duration, cause error) error {
+	delay := m.opts.Retry.backoff(retry, m.jitter.int63n)
+	if minDelay > delay {
+		delay = minDelay
+	}
+
+	metrics.MISPRetries.WithLabelValues(reason).Inc()
+
+	m.logger.WithError(cause).WithField("tries", retry+1).WithField("max_tries", m.opts.Retry.MaxRetries+1).
+		WithField("delay", delay.String()).Warn("MISP request failed, retrying")
+
+	return sleep(ctx, delay)
+}
@@ -0,0 +1,266 @@
+package misp
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryableStatus(t *testing.T) {
+	tests := []struct {
+		code int
+		want bool
+	}{
+		{code: http.StatusBadRequest, want: false},
+		{code: http.StatusForbidden, want: false},
+		{code: http.StatusNotFound, want: false},
+		{code: http.StatusRequestTimeout, want: true},
+		{code: http.StatusTooManyRequests, want: true},
+		{code: http.StatusInternalServerError, want: true},
+		{code: http.StatusNotImplemented, want: false},
+		{code: http.StatusBadGateway, want: true},
+		{code: http.StatusServiceUnavailable, want: true},
+		{code: http.StatusHTTPVersionNotSupported, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := retryableStatus(tt.code); got != tt.want {
+			t.Errorf("retryableStatus(%d) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{name: "absent", value: "", min: 0, max: 0},
+		{name: "seconds", value: "30", min: 30 * time.Second, max: 30 * time.Second},
+		{name: "negative seconds", value: "-5", min: 0, max: 0},
+		{name: "capped", value: "86400", min: mispMaxRetryAfter, max: mispMaxRetryAfter},
+		{name: "http date", value: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), min: 55 * time.Second, max: time.Minute},
+		{name: "past date", value: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), min: 0, max: 0},
+		{name: "garbage", value: "soon", min: 0, max: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("Retry-After", tt.value)
+			}
+
+			if got := retryAfter(header); got < tt.min || got > tt.max {
+				t.Errorf("retryAfter(%q) = %s, want between %s and %s", tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	noJitter := func(int64) int64 { return 0 }
+	fullJitter := func(n int64) int64 { return n - 1 }
+
+	tests := []struct {
+		retry int
+		min   time.Duration
+		max   time.Duration
+	}{
+		{retry: 0, min: 500 * time.Millisecond, max: time.Second},
+		{retry: 1, min: time.Second, max: 2 * time.Second},
+		{retry: 3, min: 4 * time.Second, max: 8 * time.Second},
+		{retry: 4, min: 5 * time.Second, max: 10 * time.Second},
+		{retry: 100, min: 5 * time.Second, max: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := policy.backoff(tt.retry, noJitter); got != tt.min {
+			t.Errorf("backoff(%d) without jitter = %s, want %s", tt.retry, got, tt.min)
+		}
+
+		if got := policy.backoff(tt.retry, fullJitter); got != tt.max {
+			t.Errorf("backoff(%d) with full jitter = %s, want %s", tt.retry, got, tt.max)
+		}
+	}
+}
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		want   RetryPolicy
+	}{
+		{
+			name: "zero takes defaults",
+			want: RetryPolicy{MaxRetries: defaultMaxRetries, BaseDelay: defaultRetryBaseDelay, MaxDelay: defaultRetryMaxDelay},
+		},
+		{
+			name:   "negative disables retries",
+			policy: RetryPolicy{MaxRetries: -1},
+			want:   RetryPolicy{MaxRetries: 0, BaseDelay: defaultRetryBaseDelay, MaxDelay: defaultRetryMaxDelay},
+		},
+		{
+			name:   "max delay is at least the base delay",
+			policy: RetryPolicy{MaxRetries: 2, BaseDelay: time.Hour, MaxDelay: time.Second},
+			want:   RetryPolicy{MaxRetries: 2, BaseDelay: time.Hour, MaxDelay: time.Hour},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.policy.setDefaults()
+			if tt.policy != tt.want {
+				t.Errorf("setDefaults() = %+v, want %+v", tt.policy, tt.want)
+			}
+		})
+	}
+}
+
+// fakeResponse is what the fake MISP answers to a single request.
+type fakeResponse struct {
+	code       int
+	retryAfter string
+}
+
+func newTestMISP(t *testing.T, responses []fakeResponse, retries int) (*MISP, *atomic.Int32) {
+	t.Helper()
+
+	requests := &atomic.Int32{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := int(requests.Add(1)) - 1
+
+		response := responses[len(responses)-1]
+		if request < len(responses) {
+			response = responses[request]
+		}
+
+		if response.retryAfter != "" {
+			w.Header().Set("Retry-After", response.retryAfter)
+		}
+
+		w.WriteHeader(response.code)
+		_, _ = io.WriteString(w, "body")
+	}))
+	t.Cleanup(server.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	client, err := New(logger, server.URL, "key", Options{
+		RequestTimeout: 5 * time.Second,
+		Retry:          RetryPolicy{MaxRetries: retries, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("could not create client: %v", err)
+	}
+
+	return client, requests
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []fakeResponse
+		retries      int
+		wantRequests int
+		wantErr      string
+		minDuration  time.Duration
+	}{
+		{
+			name:         "success",
+			responses:    []fakeResponse{{code: http.StatusOK}},
+			retries:      3,
+			wantRequests: 1,
+		},
+		{
+			name:         "retried until success",
+			responses:    []fakeResponse{{code: http.StatusBadGateway}, {code: http.StatusServiceUnavailable}, {code: http.StatusOK}},
+			retries:      3,
+			wantRequests: 3,
+		},
+		{
+			name:         "not retried on client error",
+			responses:    []fakeResponse{{code: http.StatusForbidden}},
+			retries:      3,
+			wantRequests: 1,
+			wantErr:      "invalid response code: 403",
+		},
+		{
+			name:         "gives up after max retries",
+			responses:    []fakeResponse{{code: http.StatusInternalServerError}},
+			retries:      2,
+			wantRequests: 3,
+			wantErr:      "tries 3",
+		},
+		{
+			name:         "waits for retry after",
+			responses:    []fakeResponse{{code: http.StatusTooManyRequests, retryAfter: "1"}, {code: http.StatusOK}},
+			retries:      1,
+			wantRequests: 2,
+			minDuration:  time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, requests := newTestMISP(t, tt.responses, tt.retries)
+
+			handled := false
+			start := time.Now()
+
+			err := client.do(context.Background(), client.baseURL, []byte("{}"), func(body io.Reader) error {
+				handled = true
+				return nil
+			})
+
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("do() error = %v", err)
+			}
+
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("do() error = %v, want %q", err, tt.wantErr)
+			}
+
+			if handled != (tt.wantErr == "") {
+				t.Errorf("do() handled response = %v, want %v", handled, tt.wantErr == "")
+			}
+
+			if got := int(requests.Load()); got != tt.wantRequests {
+				t.Errorf("do() sent %d requests, want %d", got, tt.wantRequests)
+			}
+
+			if elapsed := time.Since(start); elapsed < tt.minDuration {
+				t.Errorf("do() retried after %s, want at least %s", elapsed, tt.minDuration)
+			}
+		})
+	}
+}
+
+func TestDoCancelled(t *testing.T) {
+	client, requests := newTestMISP(t, []fakeResponse{{code: http.StatusServiceUnavailable, retryAfter: "60"}}, 5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := client.do(ctx, client.baseURL, []byte("{}"), func(io.Reader) error { return nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("do() error = %v, want the run deadline", err)
+	}
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("do() sent %d requests, want 1", got)
+	}
+}
@@ -0,0 +1,243 @@
+package misp
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/metrics"
+	"net/url"
+	"time"
+)
+
+// SourceConfig selects what is exported from MISP.
+type SourceConfig struct {
+	DaysToFetch    uint32
+	TypesToFetch   []string
+	ObjectsToFetch []string
+	SkipObjects    bool
+	// MaxTLP is the most restrictive TLP level that is still exported
+	MaxTLP string
+	// FetchTimeout bounds fetching all indicators of a run, zero does not limit it
+	FetchTimeout time.Duration
+}
+
+// Source exports MISP attributes and objects as source-neutral indicators.
+type Source struct {
+	client *MISP
+	conf   SourceConfig
+	name   string
+}
+
+var _ indicator.Source = (*Source)(nil)
+
+// NewSource creates an indicator source that is named after the MISP hostname.
+func NewSource(client *MISP, conf SourceConfig) *Source {
+	name := client.baseURL
+	if baseURL, err := url.Parse(client.baseURL); err != nil {
+		client.logger.WithError(err).WithField("misp_url", client.baseURL).Error("could not parse MISP URL")
+	} else {
+		name = baseURL.Hostname()
+	}
+
+	return &Source{
+		client: client,
+		conf:   conf,
+		name:   name,
+	}
+}
+
+// Name returns the MISP hostname.
+func (s *Source) Name() string {
+	return s.name
+}
+
+// FetchIndicators streams the attributes and objects to export page by page, attributes that are part of an exported object are left out.
+// When since is set only changes after it are fetched, otherwise the last days_to_fetch days are.
+func (s *Source) FetchIndicators(ctx context.Context, since time.Time, fn func([]indicator.Indicator) error) error {
+	s.client.logger.WithField("since", since).Info("fetching indicators from MISP")
+
+	if s.conf.FetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.conf.FetchTimeout)
+		defer cancel()
+	}
+
+	// objects go first so their attributes can be left out, only their IDs are kept around
+	objectIDs := make(map[string]bool)
+	skipped := 0
+	links := newEventLinks(s.client, s.conf.MaxTLP)
+
+	submit := func(attributes []Attribute, objects []Object) error {
+		numSkipped, err := s.submitPage(ctx, links, attributes, objects, fn)
+		skipped += numSkipped
+		return err
+	}
+
+	if !s.conf.SkipObjects {
+		s.client.logger.Info("fetching objects from MISP")
+
+		if err := s.client.FetchObjects(ctx, s.conf.DaysToFetch, since, s.conf.ObjectsToFetch, func(objects []Object) error {
+			for _, object := range objects {
+				objectIDs[object.ID] = true
+			}
+
+			return submit(nil, objects)
+		}); err != nil {
+			return fmt.Errorf("could not fetch MISP objects: %v", err)
+		}
+	}
+
+	if err := s.client.FetchIndicators(ctx, s.conf.DaysToFetch, since, s.conf.TypesToFetch, func(page []Attribute) error {
+		attributes := make([]Attribute, 0, len(page))
+		for _, attribute := range page {
+			if objectIDs[attribute.ObjectID] {
+				metrics.MISPAttributesFiltered.WithLabelValues(metrics.FilteredObjectMember).Inc()
+				continue
+			}
+
+			attributes = append(attributes, attribute)
+		}
+
+		return submit(attributes, nil)
+	}); err != nil {
+		return fmt.Errorf("could not fetch MISP TI indicators: %v", err)
+	}
+
+	if skipped > 0 {
+		s.client.logger.WithField("skipped", skipped).WithField("max_tlp", s.conf.MaxTLP).
+			Info("skipped MISP indicators above the maximum TLP")
+	}
+
+	return nil
+}
+
+// FetchCurrent streams the current version of the referenced attributes and objects page by page, so new sightings and
+// decay are picked up even though they do not change the modification time. Retracted indicators are left out.
+func (s *Source) FetchCurrent(ctx context.Context, refs []indicator.Ref, fn func([]indicator.Indicator) error) error {
+	if s.conf.FetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.conf.FetchTimeout)
+		defer cancel()
+	}
+
+	attributeUUIDs := make([]string, 0)
+	objectUUIDs := make([]string, 0)
+
+	for _, ref := range refs {
+		if ref.Composite {
+			objectUUIDs = append(objectUUIDs, ref.UUID)
+		} else {
+			attributeUUIDs = append(attributeUUIDs, ref.UUID)
+		}
+	}
+
+	links := newEventLinks(s.client, s.conf.MaxTLP)
+
+	if err := s.client.FetchCurrentObjects(ctx, objectUUIDs, func(objects []Object) error {
+		_, err := s.submitPage(ctx, links, nil, objects, fn)
+		return err
+	}); err != nil {
+		return fmt.Errorf("could not fetch current MISP objects: %v", err)
+	}
+
+	if err := s.client.FetchCurrentAttributes(ctx, attributeUUIDs, func(attributes []Attribute) error {
+		_, err := s.submitPage(ctx, links, attributes, nil, fn)
+		return err
+	}); err != nil {
+		return fmt.Errorf("could not fetch current MISP attributes: %v", err)
+	}
+
+	return nil
+}
+
+// submitPage converts a page of attributes and objects and passes what is within the maximum TLP on to fn.
+// It returns the amount of indicators that were skipped for their TLP.
+func (s *Source) submitPage(ctx context.Context, links *eventLinks, attributes []Attribute, objects []Object, fn func([]indicator.Indicator) error) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := links.add(ctx, attributes, objects); err != nil {
+		return 0, fmt.Errorf("could not fetch MISP event links: %v", err)
+	}
+
+	converted := make([]indicator.Indicator, 0, len(attributes)+len(objects))
+	for _, attribute := range attributes {
+		converted = append(converted, s.client.attributeIndicator(attribute))
+	}
+
+	for _, object := range objects {
+		converted = append(converted, s.client.objectIndicator(object))
+	}
+
+	// without a modification time an indicator could not be ordered against the high-water mark
+	indicators := make([]indicator.Indicator, 0, len(converted))
+	for _, candidate := range converted {
+		if candidate.Modified.IsZero() {
+			metrics.MISPAttributesFiltered.WithLabelValues(metrics.FilteredTimestamp).Inc()
+			continue
+		}
+
+		indicators = append(indicators, candidate)
+	}
+
+	filtered := indicator.FilterTLP(indicators, s.conf.MaxTLP)
+	skipped := len(indicators) - len(filtered)
+	metrics.MISPAttributesFiltered.WithLabelValues(metrics.FilteredTLP).Add(float64(skipped))
+
+	if len(filtered) == 0 {
+		return skipped, nil
+	}
+
+	return skipped, fn(filtered)
+}
+
+// FetchRetractions looks up the previously exported attributes and objects that were retracted in MISP.
+func (s *Source) FetchRetractions(ctx context.Context, refs []indicator.Ref) (map[string]indicator.Retraction, error) {
+	attributeUUIDs := make([]string, 0)
+	objectUUIDs := make([]string, 0)
+
+	for _, ref := range refs {
+		if ref.Composite {
+			objectUUIDs = append(objectUUIDs, ref.UUID)
+		} else {
+			attributeUUIDs = append(attributeUUIDs, ref.UUID)
+		}
+	}
+
+	attributeRetractions, err := s.client.FetchRetractions(ctx, attributeUUIDs, s.conf.MaxTLP)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch MISP retractions: %v", err)
+	}
+
+	objectRetractions, err := s.client.FetchObjectRetractions(ctx, objectUUIDs, s.conf.MaxTLP)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch MISP object retractions: %v", err)
+	}
+
+	retractions := make(map[string]indicator.Retraction, len(attributeRetractions)+len(objectRetractions))
+
+	for uuid, retraction := range attributeRetractions {
+		neutral := indicator.Retraction{Reason: retraction.Reason}
+		if retraction.Attribute != nil {
+			if attributeIndicator := s.client.attributeIndicator(*retraction.Attribute); !attributeIndicator.Modified.IsZero() {
+				neutral.Indicator = &attributeIndicator
+			}
+		}
+
+		retractions[uuid] = neutral
+	}
+
+	for uuid, retraction := range objectRetractions {
+		neutral := indicator.Retraction{Reason: retraction.Reason}
+		if retraction.Object != nil {
+			if objectIndicator := s.client.objectIndicator(*retraction.Object); !objectIndicator.Modified.IsZero() {
+				neutral.Indicator = &objectIndicator
+			}
+		}
+
+		retractions[uuid] = neutral
+	}
+
+	return retractions, nil
+}
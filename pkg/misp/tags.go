@@ -0,0 +1,39 @@
+package misp
+
+type Tag struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// TagNames returns the names of the attribute tags, including the inherited event tags.
+func (a Attribute) TagNames() []string {
+	return tagNames(a.Tag, a.Event.Tag)
+}
+
+// TagNames returns the names of the tags of the object event and of all object attributes.
+func (o Object) TagNames() []string {
+	tags := append([]Tag{}, o.Event.Tag...)
+	for _, attribute := range o.Attribute {
+		tags = append(tags, attribute.Tag...)
+	}
+
+	return tagNames(tags)
+}
+
+func tagNames(tagLists ...[]Tag) []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, tags := range tagLists {
+		for _, tag := range tags {
+			if tag.Name == "" || seen[tag.Name] {
+				continue
+			}
+
+			seen[tag.Name] = true
+			names = append(names, tag.Name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,159 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Schedule returns the next time a job should run after the given time.
+type Schedule interface {
+	Next(time.Time) time.Time
+}
+
+// interval runs a job every interval, counted from when its previous run finished.
+type interval time.Duration
+
+func (i interval) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(i))
+}
+
+// Parse returns the schedule of either an interval or a standard five field cron expression.
+func Parse(every time.Duration, cronExpr string) (Schedule, error) {
+	if cronExpr != "" && every != 0 {
+		return nil, fmt.Errorf("set either an interval or a cron expression, not both")
+	}
+
+	if cronExpr != "" {
+		schedule, err := cron.ParseStandard(cronExpr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression '%s': %v", cronExpr, err)
+		}
+
+		return schedule, nil
+	}
+
+	if every <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
+	}
+
+	return interval(every), nil
+}
+
+// Job is a task that runs on a schedule, a random delay of up to Jitter is added to every run.
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Jitter   time.Duration
+	// Exclusive jobs wait for each other, such as jobs that change the same data
+	Exclusive bool
+	Run       func(ctx context.Context) error
+}
+
+// Scheduler runs jobs on their schedule, a job never overlaps with its own previous run or, when exclusive, with other exclusive jobs.
+type Scheduler struct {
+	logger *logrus.Entry
+	jobs   []Job
+
+	exclusive sync.Mutex
+
+	// jitter is seeded per process, so replicas that start together do not run together
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+func New(l *logrus.Logger, jobs []Job) *Scheduler {
+	return &Scheduler{
+		logger: l.WithField("module", "scheduler"),
+		jobs:   jobs,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Run schedules the jobs until stop is cancelled and then waits for the running jobs to finish.
+// Running jobs get runCtx, cancel it to abort them when they do not finish in time.
+func (s *Scheduler) Run(stop context.Context, runCtx context.Context) {
+	wg := sync.WaitGroup{}
+
+	for _, job := range s.jobs {
+		wg.Add(1)
+
+		go func(job Job) {
+			defer wg.Done()
+			s.loop(stop, runCtx, job)
+		}(job)
+	}
+
+	wg.Wait()
+}
+
+// loop runs a single job, interval jobs run right away and cron jobs wait for their first slot.
+func (s *Scheduler) loop(stop context.Context, runCtx context.Context, job Job) {
+	logger := s.logger.WithField("job", job.Name)
+
+	next := time.Now()
+	if _, ok := job.Schedule.(interval); !ok {
+		next = job.Schedule.Next(next)
+	}
+
+	for {
+		next = next.Add(s.jitter(job.Jitter))
+		logger.WithField("next_run", next.Format(time.RFC3339)).Debug("scheduled job")
+
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-stop.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if job.Exclusive {
+			logger.Debug("waiting for other exclusive jobs")
+			s.exclusive.Lock()
+
+			// do not start once stopped while waiting
+			if stop.Err() != nil {
+				s.exclusive.Unlock()
+				return
+			}
+		}
+
+		start := time.Now()
+		logger.Info("running job")
+
+		if err := job.Run(runCtx); err != nil {
+			logger.WithError(err).WithField("duration", time.Since(start).String()).Error("job failed")
+		} else {
+			logger.WithField("duration", time.Since(start).String()).Info("job finished")
+		}
+
+		if job.Exclusive {
+			s.exclusive.Unlock()
+		}
+
+		// slots that passed while the job ran are skipped instead of run back to back
+		finished := time.Now()
+		next = job.Schedule.Next(finished)
+
+		if missed := job.Schedule.Next(start); missed.Before(finished) {
+			logger.WithField("missed_run", missed.Format(time.RFC3339)).Warn("job ran past its next run, skipping it")
+		}
+	}
+}
+
+func (s *Scheduler) jitter(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+
+	s.randMu.Lock()
+	defer s.randMu.Unlock()
+
+	return time.Duration(s.rand.Int63n(int64(limit)))
+}
@@ -0,0 +1,188 @@
+package scheduler
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	from := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		every    time.Duration
+		cronExpr string
+		wantNext time.Time
+		wantErr  bool
+	}{
+		{name: "interval", every: time.Hour, wantNext: from.Add(time.Hour)},
+		{name: "cron", cronExpr: "0 * * * *", wantNext: time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{name: "daily cron", cronExpr: "15 2 * * *", wantNext: time.Date(2024, 1, 2, 2, 15, 0, 0, time.UTC)},
+		{name: "both", every: time.Hour, cronExpr: "0 * * * *", wantErr: true},
+		{name: "neither", wantErr: true},
+		{name: "negative interval", every: -time.Minute, wantErr: true},
+		{name: "invalid cron", cronExpr: "every hour", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := Parse(tt.every, tt.cronExpr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if got := schedule.Next(from); !got.Equal(tt.wantNext) {
+				t.Errorf("Next() = %s, want %s", got, tt.wantNext)
+			}
+		})
+	}
+}
+
+func newTestScheduler(jobs []Job) *Scheduler {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return New(logger, jobs)
+}
+
+func TestJitter(t *testing.T) {
+	s := newTestScheduler(nil)
+
+	tests := []struct {
+		name  string
+		limit time.Duration
+	}{
+		{name: "none", limit: 0},
+		{name: "negative", limit: -time.Second},
+		{name: "second", limit: time.Second},
+		{name: "hour", limit: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				got := s.jitter(tt.limit)
+
+				if tt.limit <= 0 && got != 0 {
+					t.Fatalf("jitter(%s) = %s, want 0", tt.limit, got)
+				}
+
+				if tt.limit > 0 && (got < 0 || got >= tt.limit) {
+					t.Fatalf("jitter(%s) = %s, want within [0, %s)", tt.limit, got, tt.limit)
+				}
+			}
+		})
+	}
+}
+
+func TestRunStops(t *testing.T) {
+	runs := &atomic.Int32{}
+
+	s := newTestScheduler([]Job{
+		{
+			Name:     "interval",
+			Schedule: interval(10 * time.Millisecond),
+			Run: func(ctx context.Context) error {
+				runs.Add(1)
+				return nil
+			},
+		},
+		{
+			// the first slot of cron jobs is never reached within the test
+			Name:     "cron",
+			Schedule: mustParse(t, 0, "0 0 1 1 *"),
+			Run: func(ctx context.Context) error {
+				t.Error("cron job ran before its first slot")
+				return nil
+			},
+		},
+	})
+
+	stop, stopped := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer stopped()
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(stop, context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after stop")
+	}
+
+	// interval jobs run right away and then every interval
+	if got := runs.Load(); got < 2 {
+		t.Errorf("interval job ran %d times, want at least 2", got)
+	}
+}
+
+func TestRunExclusive(t *testing.T) {
+	mu := sync.Mutex{}
+	running := 0
+	overlapped := false
+	runs := map[string]int{}
+
+	run := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			running++
+			if running > 1 {
+				overlapped = true
+			}
+			runs[name]++
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+
+			return nil
+		}
+	}
+
+	s := newTestScheduler([]Job{
+		{Name: "sync", Schedule: interval(time.Millisecond), Exclusive: true, Run: run("sync")},
+		{Name: "cleanup", Schedule: interval(time.Millisecond), Exclusive: true, Run: run("cleanup")},
+	})
+
+	stop, stopped := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer stopped()
+
+	s.Run(stop, context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if overlapped {
+		t.Error("exclusive jobs ran at the same time")
+	}
+
+	if runs["sync"] == 0 || runs["cleanup"] == 0 {
+		t.Errorf("both exclusive jobs should have run, got %v", runs)
+	}
+}
+
+func mustParse(t *testing.T, every time.Duration, cronExpr string) Schedule {
+	t.Helper()
+
+	schedule, err := Parse(every, cronExpr)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	return schedule
+}
@@ -0,0 +1,247 @@
+package sentinel
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/securityinsights/armsecurityinsights/v2"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ApplyPlan makes exactly the changes of a saved plan.
+func (s *Sentinel) ApplyPlan(ctx context.Context, l *logrus.Logger, plan *Plan) error {
+	logger := l.WithField("module", "sentinel_apply")
+
+	if plan.Workspace != s.workspace() {
+		return fmt.Errorf("plan was made for workspace '%s', not '%s'", plan.Workspace, s.workspace())
+	}
+
+	logger.WithField("created", plan.Created).WithField("changes", len(plan.Changes)).Info("applying plan")
+
+	applied, err := s.applyChanges(ctx, logger, plan.Source, plan.Changes)
+
+	appliedLogger := logger.WithField("created", applied[PlanCreate]).WithField("updated", applied[PlanUpdate]).
+		WithField("revoked", applied[PlanRevoke]).WithField("deleted", applied[PlanDelete])
+
+	if err != nil {
+		appliedLogger.Warn("applied part of the plan")
+		return err
+	}
+
+	appliedLogger.Info("applied plan")
+
+	return nil
+}
+
+// applyChanges makes the planned changes, ARM changes run on the worker pool and STIX changes are uploaded in batches.
+// It returns the amount of applied changes per action, also along with an error such as the *indicator.RejectedError of rejected uploads.
+func (s *Sentinel) applyChanges(ctx context.Context, logger *logrus.Entry, source string, changes []PlanChange) (map[string]int, error) {
+	applied := make(map[string]int)
+	appliedMu := sync.Mutex{}
+
+	stixChanges := make([]PlanChange, 0)
+	armChanges := make([]PlanChange, 0, len(changes))
+
+	for _, change := range changes {
+		if change.STIX != nil {
+			stixChanges = append(stixChanges, change)
+		} else {
+			armChanges = append(armChanges, change)
+		}
+	}
+
+	if len(armChanges) > 0 {
+		tiClient, err := s.newTIClient()
+		if err != nil {
+			return nil, err
+		}
+
+		pool := newWorkerPool(ctx, s.opts.Workers)
+
+		for _, change := range armChanges {
+			change := change
+
+			if !pool.submit(func(ctx context.Context) error {
+				if err := s.applyChange(ctx, logger, tiClient, change); err != nil {
+					// expired indicators that cannot be deleted are retried by the next cleanup
+					if change.Reason == reasonExpired {
+						logger.WithError(err).WithField("name", change.IndicatorName).Error("could not delete TI indicator")
+						return nil
+					}
+
+					return err
+				}
+
+				countChange(change)
+
+				appliedMu.Lock()
+				applied[change.Action] += 1
+				appliedMu.Unlock()
+
+				return nil
+			}) {
+				break
+			}
+		}
+
+		if err := pool.wait(); err != nil {
+			return applied, err
+		}
+	}
+
+	if len(stixChanges) > 0 {
+		uploaded, err := s.uploadChanges(ctx, logger, source, stixChanges)
+
+		for action, num := range uploaded {
+			applied[action] += num
+		}
+
+		if err != nil {
+			return applied, err
+		}
+	}
+
+	return applied, nil
+}
+
+// applyChange makes a single change through the ARM API and updates the sync state accordingly.
+func (s *Sentinel) applyChange(ctx context.Context, l *logrus.Entry, tiClient *insights.ThreatIntelligenceIndicatorClient, change PlanChange) error {
+	logger := l.WithField("external_id", change.ExternalID).WithField("name", change.IndicatorName)
+
+	switch change.Action {
+	case PlanUnchanged:
+		logger.Debug("indicator is up to date in Sentinel")
+
+		record, err := s.lookupState(change.UUID)
+		if err != nil || (record != nil && record.Hash == change.Hash) {
+			return err
+		}
+
+		return s.saveState(change, change.IndicatorName)
+
+	case PlanCreate:
+		resp, err := tiClient.CreateIndicator(ctx, s.creds.ResourceGroup, s.creds.WorkspaceName, insights.ThreatIntelligenceIndicatorModel{
+			Properties: change.Properties,
+		}, nil)
+		if err != nil {
+			logger.WithError(err).Error("could not push indicator")
+			return fmt.Errorf("could not push %s: %v", change.ExternalID, err)
+		}
+
+		indicatorName := ""
+		if info := resp.GetThreatIntelligenceInformation(); info != nil && info.Name != nil {
+			indicatorName = *info.Name
+		}
+
+		logger.WithField("name", indicatorName).Info("created indicator in Sentinel")
+		return s.saveState(change, indicatorName)
+
+	case PlanUpdate:
+		if _, err := tiClient.Create(ctx, s.creds.ResourceGroup, s.creds.WorkspaceName, change.IndicatorName, insights.ThreatIntelligenceIndicatorModel{
+			Properties: change.Properties,
+		}, nil); err != nil {
+			logger.WithError(err).Error("could not push indicator")
+			return fmt.Errorf("could not update indicator '%s': %v", change.IndicatorName, err)
+		}
+
+		logger.WithField("changed", strings.Join(change.Changed, ",")).Info("updated indicator in Sentinel")
+		return s.saveState(change, change.IndicatorName)
+
+	case PlanRevoke:
+		current, err := s.getIndicator(ctx, tiClient, change.IndicatorName)
+		if err != nil {
+			return err
+		}
+
+		if current == nil || current.Properties == nil {
+			logger.Debug("retracted indicator no longer exists in Sentinel")
+			return s.forgetState(change.UUID)
+		}
+
+		today := time.Now()
+		current.Properties.Revoked = to.Ptr(true)
+		current.Properties.LastUpdatedTimeUTC = to.Ptr(today.UTC().Format(time.RFC3339))
+
+		if _, err := tiClient.Create(ctx, s.creds.ResourceGroup, s.creds.WorkspaceName, change.IndicatorName, *current, nil); err != nil {
+			return fmt.Errorf("could not revoke indicator '%s': %v", change.IndicatorName, err)
+		}
+
+		logger.WithField("reason", change.Reason).Info("revoked indicator in Sentinel")
+		return s.markRevoked(change.UUID)
+
+	case PlanDelete:
+		if _, err := tiClient.Delete(ctx, s.creds.ResourceGroup, s.creds.WorkspaceName, change.IndicatorName, nil); err != nil && !isNotFound(err) {
+			return fmt.Errorf("could not delete indicator '%s': %v", change.IndicatorName, err)
+		}
+
+		logger.WithField("reason", change.Reason).Debug("deleted indicator from Sentinel")
+		return s.forgetState(change.UUID)
+
+	default:
+		return fmt.Errorf("unknown plan action '%s'", change.Action)
+	}
+}
+
+// uploadChanges uploads the STIX indicators of the changes in batches and returns the amount of accepted changes per action,
+// on errors as well so the batches that were accepted before are still counted.
+// Indicators rejected by Sentinel are logged, left out of the sync state and returned as an *indicator.RejectedError
+// once every batch was uploaded, so the sync holds back its high-water mark and fetches them again.
+func (s *Sentinel) uploadChanges(ctx context.Context, logger *logrus.Entry, source string, changes []PlanChange) (map[string]int, error) {
+	pipeline, uploadURL, err := s.newUploadPipeline()
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded := make(map[string]int)
+	rejected := &indicator.RejectedError{}
+
+	for start := 0; start < len(changes); start += uploadMaxBatchSize {
+		end := start + uploadMaxBatchSize
+		if end > len(changes) {
+			end = len(changes)
+		}
+
+		batch := make([]stixIndicator, 0, end-start)
+		for _, change := range changes[start:end] {
+			batch = append(batch, *change.STIX)
+		}
+
+		failed, err := uploadBatch(ctx, pipeline, uploadURL, source, batch)
+		if err != nil {
+			return uploaded, err
+		}
+
+		for index, change := range changes[start:end] {
+			if messages, ok := failed[index]; ok {
+				logger.WithField("id", change.STIX.ID).WithField("errors", strings.Join(messages, "; ")).
+					Error("indicator was rejected by Sentinel")
+				rejected.Add(1, change.Modified)
+				continue
+			}
+
+			if change.Action == PlanRevoke {
+				err = s.markRevoked(change.UUID)
+			} else {
+				err = s.saveState(change, change.STIX.ID)
+			}
+			if err != nil {
+				return uploaded, err
+			}
+
+			countChange(change)
+			uploaded[change.Action] += 1
+		}
+
+		logger.WithField("batch", len(batch)).WithField("failed", len(failed)).Debug("uploaded batch of TI indicators")
+	}
+
+	if rejected.Rejected > 0 {
+		return uploaded, rejected
+	}
+
+	return uploaded, nil
+}
@@ -0,0 +1,110 @@
+package sentinel
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"strings"
+)
+
+const (
+	CloudPublic     = "public"
+	CloudGovernment = "usgovernment"
+	CloudChina      = "china"
+	// CloudCustom requires the authority host and ARM endpoint to be set explicitly
+	CloudCustom = "custom"
+)
+
+type sovereignCloud struct {
+	authorityHost string
+	armEndpoint   string
+	armAudience   string
+	// uploadEndpoint is empty when the upload API location of the cloud is not known
+	uploadEndpoint string
+	// disableInstanceDiscovery keeps the credentials from asking the public cloud to validate an unknown authority host
+	disableInstanceDiscovery bool
+}
+
+var sovereignClouds = map[string]sovereignCloud{
+	CloudPublic: {
+		authorityHost:  "https://login.microsoftonline.com/",
+		armEndpoint:    "https://management.azure.com",
+		armAudience:    "https://management.core.windows.net/",
+		uploadEndpoint: "https://sentinelus.azure-api.net",
+	},
+	CloudGovernment: {
+		authorityHost: "https://login.microsoftonline.us/",
+		armEndpoint:   "https://management.usgovcloudapi.net",
+		armAudience:   "https://management.core.usgovcloudapi.net/",
+	},
+	CloudChina: {
+		authorityHost: "https://login.chinacloudapi.cn/",
+		armEndpoint:   "https://management.chinacloudapi.cn",
+		armAudience:   "https://management.core.chinacloudapi.cn/",
+	},
+}
+
+// resolveCloud returns the endpoints of the configured cloud, with the explicitly configured endpoints taking precedence.
+func resolveCloud(creds Credentials) (sovereignCloud, error) {
+	name := strings.ToLower(creds.Cloud)
+	if name == "" {
+		name = CloudPublic
+	}
+
+	endpoints, ok := sovereignClouds[name]
+	if !ok && name != CloudCustom {
+		return sovereignCloud{}, fmt.Errorf("unknown cloud '%s'", creds.Cloud)
+	}
+
+	if name == CloudCustom {
+		endpoints.disableInstanceDiscovery = true
+	}
+
+	if creds.AuthorityHost != "" {
+		endpoints.authorityHost = creds.AuthorityHost
+	}
+
+	if creds.ARMEndpoint != "" {
+		endpoints.armEndpoint = strings.TrimSuffix(creds.ARMEndpoint, "/")
+		if name == CloudCustom {
+			endpoints.armAudience = endpoints.armEndpoint
+		}
+	}
+
+	if creds.UploadEndpoint != "" {
+		endpoints.uploadEndpoint = strings.TrimSuffix(creds.UploadEndpoint, "/")
+	}
+
+	if endpoints.authorityHost == "" || endpoints.armEndpoint == "" {
+		return sovereignCloud{}, fmt.Errorf("the custom cloud requires an authority host and ARM endpoint")
+	}
+
+	return endpoints, nil
+}
+
+// configuration returns the azcore cloud configuration for the Azure SDK clients.
+func (c sovereignCloud) configuration() cloud.Configuration {
+	return cloud.Configuration{
+		ActiveDirectoryAuthorityHost: c.authorityHost,
+		Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {
+				Audience: c.armAudience,
+				Endpoint: c.armEndpoint,
+			},
+		},
+	}
+}
+
+// clientOptions returns the options every Azure client and credential is created with.
+func (s *Sentinel) clientOptions() policy.ClientOptions {
+	return policy.ClientOptions{Cloud: s.cloud.configuration(), Transport: s.transport}
+}
+
+// armOptions returns the options for the ARM clients such as the TI indicator and tables clients, which share the throttle.
+func (s *Sentinel) armOptions() *arm.ClientOptions {
+	options := s.clientOptions()
+	options.PerRetryPolicies = append(options.PerRetryPolicies, s.throttle)
+
+	return &arm.ClientOptions{ClientOptions: options}
+}
@@ -0,0 +1,522 @@
+package sentinel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/securityinsights/armsecurityinsights/v2"
+	"github.com/google/uuid"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testTenant      = "00000000-0000-0000-0000-000000000001"
+	testAccessToken = "fake-access-token"
+)
+
+// recordingTransport sends requests to the fake servers only, requests to any other host fail and are recorded.
+type recordingTransport struct {
+	client  *http.Client
+	allowed map[string]bool
+
+	mu       sync.Mutex
+	requests []string
+	denied   []string
+}
+
+func (t *recordingTransport) Do(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.requests = append(t.requests, req.Method+" "+req.URL.Host+req.URL.Path)
+	if !t.allowed[req.URL.Host] {
+		t.denied = append(t.denied, req.URL.String())
+		t.mu.Unlock()
+		return nil, fmt.Errorf("request to unexpected host '%s'", req.URL.Host)
+	}
+	t.mu.Unlock()
+
+	return t.client.Transport.RoundTrip(req)
+}
+
+// fakeCloud runs a fake authority, ARM and upload API and records what they were asked for.
+type fakeCloud struct {
+	authority *httptest.Server
+	arm       *httptest.Server
+	upload    *httptest.Server
+
+	mu          sync.Mutex
+	scopes      []string
+	armRequests []string
+	uploads     []string
+	badTokens   int
+
+	// created holds the indicators created through either API, keyed by indicator name
+	created map[string]bool
+	// indicators holds the properties of the indicators created through either API, keyed by indicator name.
+	// Uploaded indicators get a GUID name and their STIX ID as external ID, as in Sentinel.
+	indicators map[string]json.RawMessage
+	// reject makes the upload API reject the STIX indicators with these IDs
+	reject map[string]bool
+	// uploadLimit makes the upload API fail every request after this many, zero does not limit uploads
+	uploadLimit int
+}
+
+func newFakeCloud(t *testing.T) *fakeCloud {
+	t.Helper()
+
+	fc := &fakeCloud{created: map[string]bool{}, indicators: map[string]json.RawMessage{}, reject: map[string]bool{}}
+
+	fc.authority = httptest.NewTLSServer(http.HandlerFunc(fc.serveAuthority))
+	t.Cleanup(fc.authority.Close)
+
+	fc.arm = httptest.NewTLSServer(http.HandlerFunc(fc.serveARM))
+	t.Cleanup(fc.arm.Close)
+
+	fc.upload = httptest.NewTLSServer(http.HandlerFunc(fc.serveUpload))
+	t.Cleanup(fc.upload.Close)
+
+	return fc
+}
+
+func (fc *fakeCloud) serveAuthority(w http.ResponseWriter, r *http.Request) {
+	tenantPath := "/" + testTenant
+
+	switch {
+	case r.URL.Path == tenantPath+"/v2.0/.well-known/openid-configuration":
+		writeJSON(w, map[string]string{
+			"authorization_endpoint": fc.authority.URL + tenantPath + "/oauth2/v2.0/authorize",
+			"token_endpoint":         fc.authority.URL + tenantPath + "/oauth2/v2.0/token",
+			"issuer":                 fc.authority.URL + tenantPath + "/v2.0",
+		})
+	case r.URL.Path == tenantPath+"/oauth2/v2.0/token":
+		_ = r.ParseForm()
+
+		fc.mu.Lock()
+		fc.scopes = append(fc.scopes, r.PostForm.Get("scope"))
+		fc.mu.Unlock()
+
+		writeJSON(w, map[string]interface{}{
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"access_token": testAccessToken,
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (fc *fakeCloud) authorized(r *http.Request) bool {
+	if r.Header.Get("Authorization") == "Bearer "+testAccessToken {
+		return true
+	}
+
+	fc.mu.Lock()
+	fc.badTokens++
+	fc.mu.Unlock()
+
+	return false
+}
+
+func (fc *fakeCloud) serveARM(w http.ResponseWriter, r *http.Request) {
+	if !fc.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	fc.mu.Lock()
+	fc.armRequests = append(fc.armRequests, r.Method+" "+r.URL.Path)
+	fc.mu.Unlock()
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/threatIntelligence/main/createIndicator"):
+		var model struct {
+			Properties json.RawMessage `json:"properties"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&model); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fc.mu.Lock()
+		name := fmt.Sprintf("created-%d", len(fc.created))
+		fc.created[name] = true
+		fc.indicators[name] = model.Properties
+		fc.mu.Unlock()
+
+		writeJSON(w, fakeIndicator(name, model.Properties))
+	case strings.HasSuffix(r.URL.Path, "/threatIntelligence/main/queryIndicators"):
+		var criteria insights.ThreatIntelligenceFilteringCriteria
+		if err := json.NewDecoder(r.Body).Decode(&criteria); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fc.mu.Lock()
+		value := make([]interface{}, 0, len(fc.indicators))
+		for name, properties := range fc.indicators {
+			if matchesCriteria(properties, criteria) {
+				value = append(value, fakeIndicator(name, properties))
+			}
+		}
+		fc.mu.Unlock()
+
+		writeJSON(w, map[string]interface{}{"value": value})
+	case strings.Contains(r.URL.Path, "/threatIntelligence/main/indicators/") && r.Method == http.MethodGet:
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+		fc.mu.Lock()
+		properties, ok := fc.indicators[name]
+		fc.mu.Unlock()
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		writeJSON(w, fakeIndicator(name, properties))
+	case strings.Contains(r.URL.Path, "/threatIntelligence/main/indicators/") && r.Method == http.MethodDelete:
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+		fc.mu.Lock()
+		delete(fc.indicators, name)
+		delete(fc.created, name)
+		fc.mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	case strings.Contains(r.URL.Path, "/threatIntelligence/main/indicators/") && r.Method == http.MethodPut:
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+		var model struct {
+			Properties json.RawMessage `json:"properties"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&model); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+
+		stored, ok := fc.indicators[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		// an update replaces the properties of the same indicator, it cannot move it to another source or external ID
+		var current, updated struct {
+			Source     string `json:"source"`
+			ExternalID string `json:"externalId"`
+		}
+		if json.Unmarshal(stored, &current) != nil || json.Unmarshal(model.Properties, &updated) != nil || current != updated {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fc.indicators[name] = model.Properties
+		writeJSON(w, fakeIndicator(name, model.Properties))
+	case strings.Contains(r.URL.Path, "/tables/") && r.Method == http.MethodPut:
+		writeJSON(w, map[string]interface{}{
+			"properties": map[string]interface{}{"provisioningState": "Succeeded"},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (fc *fakeCloud) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if !fc.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var request uploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if fc.uploadLimit > 0 && len(fc.uploads) >= fc.uploadLimit {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	fc.uploads = append(fc.uploads, r.Method+" "+r.URL.Path)
+
+	errors := make([]map[string]interface{}, 0)
+	for i, stix := range request.Indicators {
+		if fc.reject[stix.ID] {
+			errors = append(errors, map[string]interface{}{"recordIndex": i, "errorMessages": []string{"rejected"}})
+			continue
+		}
+
+		// a new revision of an uploaded indicator replaces it under the same name
+		name := fc.uploadedName(stix.ID)
+		if name == "" {
+			name = uuid.New().String()
+		}
+
+		properties, _ := json.Marshal(map[string]interface{}{
+			"source":      request.SourceSystem,
+			"externalId":  stix.ID,
+			"displayName": stix.Name,
+			"description": stix.Description,
+			"confidence":  stix.Confidence,
+			"pattern":     stix.Pattern,
+			"revoked":     stix.Revoked,
+			"validUntil":  stix.ValidUntil,
+			"created":     stix.Created,
+		})
+
+		fc.created[name] = true
+		fc.indicators[name] = properties
+	}
+
+	writeJSON(w, map[string]interface{}{"errors": errors})
+}
+
+// uploadedName returns the name of the indicator uploaded with the STIX ID, fc.mu must be held.
+func (fc *fakeCloud) uploadedName(id string) string {
+	for name, raw := range fc.indicators {
+		var properties struct {
+			ExternalID string `json:"externalId"`
+		}
+
+		if json.Unmarshal(raw, &properties) == nil && properties.ExternalID == id {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// matchesCriteria filters indicators like Sentinel: the source filter only matches the source property,
+// disabled means revoked and validity is compared by time.
+func matchesCriteria(raw json.RawMessage, criteria insights.ThreatIntelligenceFilteringCriteria) bool {
+	var properties struct {
+		Source     string `json:"source"`
+		Revoked    bool   `json:"revoked"`
+		ValidUntil string `json:"validUntil"`
+	}
+	if err := json.Unmarshal(raw, &properties); err != nil {
+		return false
+	}
+
+	if properties.Revoked && (criteria.IncludeDisabled == nil || !*criteria.IncludeDisabled) {
+		return false
+	}
+
+	if criteria.MaxValidUntil != nil {
+		validUntil, err := time.Parse(time.RFC3339, properties.ValidUntil)
+		maxValidUntil, _ := time.Parse(time.RFC3339, *criteria.MaxValidUntil)
+		if err != nil || validUntil.After(maxValidUntil) {
+			return false
+		}
+	}
+
+	if len(criteria.Sources) == 0 {
+		return true
+	}
+
+	for _, source := range criteria.Sources {
+		if source != nil && strings.EqualFold(*source, properties.Source) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func fakeIndicator(name string, properties json.RawMessage) map[string]interface{} {
+	return map[string]interface{}{"kind": "indicator", "name": name, "properties": properties}
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func hostOf(t *testing.T, rawURL string) string {
+	t.Helper()
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("invalid url '%s': %v", rawURL, err)
+	}
+
+	return parsed.Host
+}
+
+// newTestSentinel creates a Sentinel instance that can only reach the fake cloud.
+func newTestSentinel(t *testing.T, fc *fakeCloud, store *state.Store, opts Options) (*Sentinel, *recordingTransport) {
+	t.Helper()
+
+	transport := &recordingTransport{
+		// the fake servers share a certificate, so one client trusts all of them
+		client: fc.authority.Client(),
+		allowed: map[string]bool{
+			hostOf(t, fc.authority.URL): true,
+			hostOf(t, fc.arm.URL):       true,
+			hostOf(t, fc.upload.URL):    true,
+		},
+	}
+
+	creds := Credentials{
+		TenantID:       testTenant,
+		ClientID:       "client",
+		ClientSecret:   "secret",
+		SubscriptionID: "subscription",
+		ResourceGroup:  "group",
+		WorkspaceName:  "workspace",
+		WorkspaceID:    "workspace-id",
+		Cloud:          CloudCustom,
+		AuthorityHost:  fc.authority.URL + "/",
+		ARMEndpoint:    fc.arm.URL + "/",
+		UploadEndpoint: fc.upload.URL,
+	}
+
+	s, err := newSentinel(creds, store, opts, transport)
+	if err != nil {
+		t.Fatalf("could not create sentinel: %v", err)
+	}
+
+	return s, transport
+}
+
+func TestCustomCloudRouting(t *testing.T) {
+	fc := newFakeCloud(t)
+	s, transport := newTestSentinel(t, fc, nil, Options{})
+
+	ctx := context.Background()
+
+	if err := s.Inventory(ctx, "MISP", false, func(InventoryIndicator) error { return nil }); err != nil {
+		t.Fatalf("could not list threat intel: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	if err := s.CreateTable(ctx, logger, 30); err != nil {
+		t.Fatalf("could not create table: %v", err)
+	}
+
+	pipeline, uploadURL, err := s.newUploadPipeline()
+	if err != nil {
+		t.Fatalf("could not create upload pipeline: %v", err)
+	}
+
+	if _, err := uploadBatch(ctx, pipeline, uploadURL, "MISP", []stixIndicator{{Type: "indicator"}}); err != nil {
+		t.Fatalf("could not upload: %v", err)
+	}
+
+	if len(transport.denied) > 0 {
+		t.Errorf("requests left the configured cloud: %v", transport.denied)
+	}
+
+	if fc.badTokens > 0 {
+		t.Errorf("%d requests did not carry the token of the custom authority", fc.badTokens)
+	}
+
+	wantScope := fc.arm.URL + "/.default"
+	if len(fc.scopes) == 0 {
+		t.Errorf("no token was requested from the custom authority")
+	}
+
+	for _, scope := range fc.scopes {
+		if !strings.Contains(scope, wantScope) {
+			t.Errorf("token requested for scope '%s', want audience '%s'", scope, wantScope)
+		}
+	}
+
+	checkRequest := func(name string, requests []string, want string) {
+		for _, request := range requests {
+			if strings.Contains(request, want) {
+				return
+			}
+		}
+
+		t.Errorf("no %s request containing '%s' reached the fake server, got %v", name, want, requests)
+	}
+
+	checkRequest("threat intel", fc.armRequests, "/providers/Microsoft.SecurityInsights/threatIntelligence/main/queryIndicators")
+	checkRequest("table", fc.armRequests, "/providers/Microsoft.OperationalInsights/workspaces/workspace/tables/")
+	checkRequest("upload", fc.uploads, "/workspace-id/threatintelligence:upload-indicators")
+}
+
+func TestResolveCloud(t *testing.T) {
+	tests := []struct {
+		name    string
+		creds   Credentials
+		want    sovereignCloud
+		wantErr bool
+	}{
+		{
+			name:  "default is public",
+			creds: Credentials{},
+			want:  sovereignClouds[CloudPublic],
+		},
+		{
+			name:  "government",
+			creds: Credentials{Cloud: "USGovernment"},
+			want:  sovereignClouds[CloudGovernment],
+		},
+		{
+			name:  "upload endpoint override",
+			creds: Credentials{Cloud: CloudChina, UploadEndpoint: "https://upload.example.cn/"},
+			want: sovereignCloud{
+				authorityHost:  sovereignClouds[CloudChina].authorityHost,
+				armEndpoint:    sovereignClouds[CloudChina].armEndpoint,
+				armAudience:    sovereignClouds[CloudChina].armAudience,
+				uploadEndpoint: "https://upload.example.cn",
+			},
+		},
+		{
+			name: "custom",
+			creds: Credentials{
+				Cloud:         CloudCustom,
+				AuthorityHost: "https://login.example.com/",
+				ARMEndpoint:   "https://arm.example.com/",
+			},
+			want: sovereignCloud{
+				authorityHost:            "https://login.example.com/",
+				armEndpoint:              "https://arm.example.com",
+				armAudience:              "https://arm.example.com",
+				disableInstanceDiscovery: true,
+			},
+		},
+		{
+			name:    "custom without endpoints",
+			creds:   Credentials{Cloud: CloudCustom},
+			wantErr: true,
+		},
+		{
+			name:    "unknown",
+			creds:   Credentials{Cloud: "mars"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveCloud(tt.creds)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveCloud() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("resolveCloud() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package sentinel
+
+import (
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"math"
+	"strings"
+)
+
+const (
+	defaultSightingsForMax = 10
+)
+
+// ConfidencePolicy weighs the MISP decay score, sightings and confidence tags into a 0-100 confidence.
+// Only the signals that are present for an indicator are weighed, Default is used when there are none.
+type ConfidencePolicy struct {
+	Default        int
+	DecayWeight    float64
+	SightingWeight float64
+	TagWeight      float64
+	// SightingsForMax is the amount of sightings, minus false positives, that results in full sighting confidence
+	SightingsForMax int
+}
+
+// confidenceLevels maps the MISP confidence-level taxonomy onto a confidence.
+var confidenceLevels = map[string]float64{
+	"completely-confident": 100,
+	"usually-confident":    75,
+	"fairly-confident":     50,
+	"rarely-confident":     25,
+	"unconfident":          0,
+}
+
+// admiraltyScores maps the admiralty-scale source reliability and information credibility onto a confidence.
+// The 'cannot be judged' grades f and 6 are left out.
+var admiraltyScores = map[string]float64{
+	"source-reliability=\"a\"":      100,
+	"source-reliability=\"b\"":      80,
+	"source-reliability=\"c\"":      60,
+	"source-reliability=\"d\"":      40,
+	"source-reliability=\"e\"":      20,
+	"information-credibility=\"1\"": 100,
+	"information-credibility=\"2\"": 80,
+	"information-credibility=\"3\"": 60,
+	"information-credibility=\"4\"": 40,
+	"information-credibility=\"5\"": 20,
+}
+
+// confidence calculates the confidence of an indicator from its decay scores, sightings and tags.
+func (p ConfidencePolicy) confidence(ind indicator.Indicator) int {
+	total := 0.0
+	weights := 0.0
+
+	if score, ok := decayConfidence(ind.DecayScores); ok && p.DecayWeight > 0 {
+		total += score * p.DecayWeight
+		weights += p.DecayWeight
+	}
+
+	if score, ok := p.sightingConfidence(ind.Sightings, ind.FalsePositives); ok && p.SightingWeight > 0 {
+		total += score * p.SightingWeight
+		weights += p.SightingWeight
+	}
+
+	if score, ok := tagConfidence(ind.Tags); ok && p.TagWeight > 0 {
+		total += score * p.TagWeight
+		weights += p.TagWeight
+	}
+
+	if weights == 0 {
+		return p.Default
+	}
+
+	return clampConfidence(total / weights)
+}
+
+// decayConfidence returns the highest score of the decaying models that scored the attribute.
+func decayConfidence(decayScores []float64) (float64, bool) {
+	if len(decayScores) == 0 {
+		return 0, false
+	}
+
+	highest := 0.0
+	for _, decayScore := range decayScores {
+		if decayScore > highest {
+			highest = decayScore
+		}
+	}
+
+	return highest, true
+}
+
+func (p ConfidencePolicy) sightingConfidence(sightings, falsePositives int) (float64, bool) {
+	if sightings+falsePositives == 0 {
+		return 0, false
+	}
+
+	seen := sightings - falsePositives
+
+	forMax := p.SightingsForMax
+	if forMax <= 0 {
+		forMax = defaultSightingsForMax
+	}
+
+	return math.Max(0, math.Min(100, 100*float64(seen)/float64(forMax))), true
+}
+
+// tagConfidence averages the confidence-level and admiralty-scale tags.
+func tagConfidence(tags []string) (float64, bool) {
+	total := 0.0
+	found := 0
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+
+		var score float64
+		var ok bool
+
+		switch {
+		case strings.HasPrefix(tag, "confidence-level:"):
+			score, ok = confidenceLevels[strings.TrimPrefix(tag, "confidence-level:")]
+		case strings.HasPrefix(tag, "admiralty-scale:"):
+			score, ok = admiraltyScores[strings.TrimPrefix(tag, "admiralty-scale:")]
+		}
+
+		if ok {
+			total += score
+			found += 1
+		}
+	}
+
+	if found == 0 {
+		return 0, false
+	}
+
+	return total / float64(found), true
+}
+
+func clampConfidence(score float64) int {
+	return int(math.Round(math.Max(0, math.Min(100, score))))
+}
@@ -0,0 +1,84 @@
+package sentinel
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"os"
+)
+
+const (
+	AuthClientSecret      = "client_secret"
+	AuthClientCertificate = "client_certificate"
+	AuthManagedIdentity   = "managed_identity"
+	AuthWorkloadIdentity  = "workload_identity"
+	AuthEnvironment       = "environment"
+	AuthAzureCLI          = "azure_cli"
+	// AuthDefault tries the environment, workload identity, managed identity and the Azure CLI in turn
+	AuthDefault = "default"
+)
+
+// newTokenCredential creates the credential for the configured authentication method, defaulting to a client secret.
+// Instance discovery is disabled for authority hosts the public cloud does not know, such as those of custom clouds.
+func newTokenCredential(creds Credentials, options azcore.ClientOptions, disableInstanceDiscovery bool) (azcore.TokenCredential, error) {
+	switch creds.AuthMethod {
+	case AuthClientSecret, "":
+		return azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, creds.ClientSecret,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: options, DisableInstanceDiscovery: disableInstanceDiscovery})
+
+	case AuthClientCertificate:
+		certData, err := os.ReadFile(creds.CertificatePath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read certificate '%s': %v", creds.CertificatePath, err)
+		}
+
+		var password []byte
+		if creds.CertificatePassword != "" {
+			password = []byte(creds.CertificatePassword)
+		}
+
+		certs, key, err := azidentity.ParseCertificates(certData, password)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse certificate '%s': %v", creds.CertificatePath, err)
+		}
+
+		return azidentity.NewClientCertificateCredential(creds.TenantID, creds.ClientID, certs, key,
+			&azidentity.ClientCertificateCredentialOptions{ClientOptions: options, DisableInstanceDiscovery: disableInstanceDiscovery})
+
+	case AuthManagedIdentity:
+		miOptions := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: options}
+		if creds.ClientID != "" {
+			// a user-assigned identity, otherwise the system-assigned one is used
+			miOptions.ID = azidentity.ClientID(creds.ClientID)
+		}
+
+		return azidentity.NewManagedIdentityCredential(miOptions)
+
+	case AuthWorkloadIdentity:
+		// empty values are read from the environment set up by the AKS workload identity webhook
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions:            options,
+			TenantID:                 creds.TenantID,
+			ClientID:                 creds.ClientID,
+			TokenFilePath:            creds.FederatedTokenFile,
+			DisableInstanceDiscovery: disableInstanceDiscovery,
+		})
+
+	case AuthEnvironment:
+		return azidentity.NewEnvironmentCredential(&azidentity.EnvironmentCredentialOptions{ClientOptions: options})
+
+	case AuthAzureCLI:
+		// the Azure CLI authenticates against the cloud it was logged in to
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{TenantID: creds.TenantID})
+
+	case AuthDefault:
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			ClientOptions:            options,
+			TenantID:                 creds.TenantID,
+			DisableInstanceDiscovery: disableInstanceDiscovery,
+		})
+
+	default:
+		return nil, fmt.Errorf("unknown authentication method '%s'", creds.AuthMethod)
+	}
+}
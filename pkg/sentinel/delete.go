@@ -3,12 +3,9 @@ package sentinel
 import (
 	"context"
 	"fmt"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/securityinsights/armsecurityinsights/v2"
 	"github.com/sirupsen/logrus"
-	"net/http"
 	"strings"
 	"time"
 )
@@ -18,100 +15,107 @@ const (
 	sentinelDeletePageSize = 5000
 )
 
-func (s *Sentinel) CleanupThreatIntel(ctx context.Context, l *logrus.Logger) error {
+// isOwnIndicator returns whether the indicator was created by one of the given sources.
+func isOwnIndicator(properties *insights.ThreatIntelligenceIndicatorProperties, sources []string) bool {
+	if properties == nil {
+		return false
+	}
+
+	for _, source := range sources {
+		if strings.EqualFold(stringValue(properties.Source), source) ||
+			strings.EqualFold(stringValue(properties.CreatedByRef), source) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CleanupThreatIntel deletes the expired indicators that were created by one of the given sources.
+func (s *Sentinel) CleanupThreatIntel(ctx context.Context, l *logrus.Logger, sources []string) error {
 	logger := l.WithField("module", "sentinel_ti")
 
-	cred, err := azidentity.NewClientSecretCredential(s.creds.TenantID, s.creds.ClientID, s.creds.ClientSecret, nil)
+	changes, err := s.planCleanup(ctx, logger, sources)
 	if err != nil {
-		return fmt.Errorf("could not authenticate to MS Sentinel: %v", err)
+		return err
 	}
 
-	tiClient, err := insights.NewThreatIntelligenceIndicatorClient(s.creds.SubscriptionID, cred, nil)
+	applied, err := s.applyChanges(ctx, logger, "", changes)
 	if err != nil {
-		return fmt.Errorf("could not create TI client: %v", err)
+		return err
 	}
 
-	yesterday := time.Now().AddDate(0, 0, -1)
+	if applied[PlanDelete] > 0 {
+		logger.WithField("num", applied[PlanDelete]).Info("deleted expired TI indicators")
+	} else {
+		logger.Info("no TI indicators to delete")
+	}
 
-	hasMorePages := true
-	totalDeleted := 0
-	pages := 0
+	return nil
+}
 
-	deleted := make(map[string]bool)
+// planCleanup lists the deletes of the expired indicators that were created by one of the given sources.
+func (s *Sentinel) planCleanup(ctx context.Context, logger *logrus.Entry, sources []string) ([]PlanChange, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no sources to clean up provided")
+	}
 
-	for hasMorePages {
-		logger.WithField("page", pages).WithField("total_deleted", totalDeleted).Info("retrieving expired TI indicators")
-		pager := tiClient.NewQueryIndicatorsPager(s.creds.ResourceGroup, s.creds.WorkspaceName, insights.ThreatIntelligenceFilteringCriteria{
-			IncludeDisabled: to.Ptr(false),
-			MaxValidUntil:   to.Ptr(yesterday.Format(time.RFC3339)),
-			PageSize:        to.Ptr[int32](sentinelDeletePageSize),
-		}, nil)
+	tiClient, err := s.newTIClient()
+	if err != nil {
+		return nil, err
+	}
+
+	yesterday := time.Now().AddDate(0, 0, -1)
 
-		if !pager.More() {
-			hasMorePages = false
-			break
+	logger.WithField("sources", strings.Join(sources, ",")).Info("retrieving expired TI indicators")
+
+	// the sync state of deleted indicators is forgotten, so it is not checked for retractions anymore
+	byName, err := s.stateByIndicatorName()
+	if err != nil {
+		return nil, err
+	}
+
+	// collect first so deleting does not shift the pages we are still reading
+	changes := make([]PlanChange, 0)
+
+	// revoked indicators expire too, and sources are only matched here as the API source filter misses indicators
+	// that carry the source in their created-by reference only
+	if err := s.walkIndicators(ctx, tiClient, insights.ThreatIntelligenceFilteringCriteria{
+		IncludeDisabled: to.Ptr(true),
+		MaxValidUntil:   to.Ptr(yesterday.Format(time.RFC3339)),
+		PageSize:        to.Ptr[int32](sentinelDeletePageSize),
+	}, func(model *insights.ThreatIntelligenceIndicatorModel) error {
+		// never touch indicators of other feeds
+		if !isOwnIndicator(model.Properties, sources) {
+			logger.WithField("name", *model.Name).Debug("skipping indicator of other source")
+			return nil
 		}
 
-		totalPageDeleted := 0
-
-	retry:
-		for pager.More() {
-			pages += 1
-
-			items, err := pager.NextPage(ctx)
-			if err != nil {
-				logger.WithError(err).Debug("could not request more TI items")
-				break
-			}
-
-			if len(items.Value) == 0 {
-				hasMorePages = false
-				break
-			}
-
-			for _, value := range items.Value {
-				ti := value.GetThreatIntelligenceInformation()
-
-				logger.WithField("name", *ti.Name).
-					WithField("progress", fmt.Sprintf("%d/%d", totalPageDeleted, len(items.Value))).
-					WithField("total_deleted", totalDeleted).
-					Debug("deleting TI indicator")
-
-				if _, ok := deleted[*ti.ID]; ok {
-					logger.WithField("id", *ti.ID).Warn("encountered TI item which was previously deleted")
-				}
-
-				deleteCtx := ctx
-				var rawResponse *http.Response
-				if logger.Logger.IsLevelEnabled(logrus.TraceLevel) {
-					deleteCtx = policy.WithCaptureResponse(ctx, &rawResponse)
-				}
-
-				_, err := tiClient.Delete(deleteCtx, s.creds.ResourceGroup, s.creds.WorkspaceName, *ti.Name, nil)
-				if err != nil {
-					if strings.Contains(err.Error(), "Number of delete requests for subscription ") {
-						logger.WithField("id", *ti.ID).Warn("exceeded request rate, waiting 30 seconds")
-						time.Sleep(time.Second * 30)
-						goto retry
-					}
-
-					logger.WithError(err).WithField("id", *ti.ID).Error("could not delete TI indicator")
-					continue
-				}
-
-				deleted[*ti.ID] = true
-
-				totalDeleted += 1
-				totalPageDeleted += 1
-			}
+		// the sync state of uploaded indicators is kept under their STIX ID
+		key := *model.Name
+		if uploaded(model) {
+			key = stringValue(model.Properties.ExternalID)
 		}
-	}
 
-	if totalDeleted > 0 {
-		logger.WithField("num", totalDeleted).Info("deleted expired TI indicators")
-	} else {
-		logger.Info("no TI indicators to delete")
+		record := byName[key]
+
+		changes = append(changes, PlanChange{
+			Action:        PlanDelete,
+			UUID:          record.AttributeUUID,
+			ExternalID:    stringValue(model.Properties.ExternalID),
+			DisplayName:   stringValue(model.Properties.DisplayName),
+			IndicatorName: *model.Name,
+			Reason:        reasonExpired,
+			ThreatType:    firstString(model.Properties.ThreatTypes),
+			EventUUID:     record.EventUUID,
+			Object:        record.Object,
+		})
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 
-	return nil
+	logger.WithField("num", len(changes)).Debug("found expired TI indicators")
+
+	return changes, nil
 }
@@ -0,0 +1,61 @@
+package sentinel
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"github.com/sirupsen/logrus"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestCleanupThreatIntel(t *testing.T) {
+	expired := time.Now().AddDate(0, 0, -7).UTC().Format(time.RFC3339)
+	valid := time.Now().AddDate(0, 1, 0).UTC().Format(time.RFC3339)
+
+	indicators := map[string]map[string]interface{}{
+		"own-expired":  {"source": "MISP", "validUntil": expired},
+		"own-revoked":  {"source": "MISP", "validUntil": expired, "revoked": true},
+		"created-by":   {"createdByRef": "MISP", "validUntil": expired},
+		"own-valid":    {"source": "MISP", "validUntil": valid},
+		"other-source": {"source": "Other feed", "validUntil": expired},
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	store := openTestStore(t)
+	if err := store.Put(state.Record{AttributeUUID: "00000000-0000-0000-0000-0000000000a1", IndicatorName: "own-expired"}); err != nil {
+		t.Fatalf("could not save sync state: %v", err)
+	}
+
+	fc := newFakeCloud(t)
+	for name, properties := range indicators {
+		raw, _ := json.Marshal(properties)
+		fc.indicators[name] = raw
+	}
+
+	s, _ := newTestSentinel(t, fc, store, Options{ExpireMonths: 6, Workers: 2})
+
+	if err := s.CleanupThreatIntel(context.Background(), logger, []string{"MISP"}); err != nil {
+		t.Fatalf("CleanupThreatIntel() error = %v", err)
+	}
+
+	remaining := make([]string, 0, len(fc.indicators))
+	for name := range fc.indicators {
+		remaining = append(remaining, name)
+	}
+	sort.Strings(remaining)
+
+	// revoked indicators and those only created by the source expire as well, other sources are never touched
+	if want := []string{"other-source", "own-valid"}; !reflect.DeepEqual(remaining, want) {
+		t.Errorf("indicators left after cleanup = %v, want %v", remaining, want)
+	}
+
+	if record, err := store.Get("00000000-0000-0000-0000-0000000000a1"); err != nil || record != nil {
+		t.Errorf("sync state of the deleted indicator = %+v, %v, want it forgotten", record, err)
+	}
+}
@@ -0,0 +1,95 @@
+package sentinel
+
+import (
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const mitreKillChainName = "mitre-attack"
+
+// galaxyLabelPrefixes maps the MISP galaxy types that are exported as labels onto their label prefix.
+var galaxyLabelPrefixes = map[string]string{
+	"mitre-attack-pattern":                   "attack-pattern",
+	"mitre-enterprise-attack-attack-pattern": "attack-pattern",
+	"threat-actor":                           "threat-actor",
+	"mitre-intrusion-set":                    "threat-actor",
+	"microsoft-activity-group":               "threat-actor",
+	"malpedia":                               "malware",
+	"mitre-malware":                          "malware",
+	"ransomware":                             "malware",
+	"mitre-tool":                             "tool",
+	"tool":                                   "tool",
+}
+
+// attackTechniqueID matches the technique ID in ATT&CK cluster values such as "Phishing - T1566".
+var attackTechniqueID = regexp.MustCompile(`\bT\d{4}(\.\d{3})?\b`)
+
+type killChainPhase struct {
+	KillChainName string `json:"kill_chain_name"`
+	PhaseName     string `json:"phase_name"`
+}
+
+// galaxyLabels returns the ATT&CK technique, threat actor, malware and tool labels of the clusters.
+func galaxyLabels(clusters []indicator.Cluster) []string {
+	labels := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, cluster := range clusters {
+		prefix, ok := galaxyLabelPrefixes[cluster.Type]
+		if !ok {
+			continue
+		}
+
+		value := cluster.Value
+		if prefix == "attack-pattern" {
+			if len(cluster.ExternalIDs) > 0 {
+				value = cluster.ExternalIDs[0]
+			} else if id := attackTechniqueID.FindString(cluster.Value); id != "" {
+				value = id
+			}
+		}
+
+		label := prefix + ":" + value
+		if !seen[label] {
+			seen[label] = true
+			labels = append(labels, label)
+		}
+	}
+
+	sort.Strings(labels)
+	return labels
+}
+
+// galaxyKillChainPhases returns the ATT&CK tactics of the attack pattern clusters.
+// Tactics are only known for clusters that were fetched with their meta, not for bare galaxy tags.
+func galaxyKillChainPhases(clusters []indicator.Cluster) []killChainPhase {
+	phases := make([]killChainPhase, 0)
+	seen := make(map[string]bool)
+
+	for _, cluster := range clusters {
+		if galaxyLabelPrefixes[cluster.Type] != "attack-pattern" {
+			continue
+		}
+
+		// kill chains are formatted as mitre-attack:<tactic> or mitre-attack:<matrix>:<tactic>
+		for _, killChain := range cluster.KillChain {
+			parts := strings.Split(killChain, ":")
+			if len(parts) < 2 || parts[0] != mitreKillChainName {
+				continue
+			}
+
+			phase := parts[len(parts)-1]
+			if phase == "" || seen[phase] {
+				continue
+			}
+
+			seen[phase] = true
+			phases = append(phases, killChainPhase{KillChainName: mitreKillChainName, PhaseName: phase})
+		}
+	}
+
+	sort.Slice(phases, func(i, j int) bool { return phases[i].PhaseName < phases[j].PhaseName })
+	return phases
+}
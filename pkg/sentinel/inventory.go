@@ -0,0 +1,133 @@
+package sentinel
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/securityinsights/armsecurityinsights/v2"
+	"strings"
+)
+
+const (
+	// fetch TI inventory per this amount
+	sentinelInventoryPageSize = 1000
+)
+
+// walkIndicators pages through every indicator matching the criteria and calls fn for each of them.
+func (s *Sentinel) walkIndicators(ctx context.Context, tiClient *insights.ThreatIntelligenceIndicatorClient, criteria insights.ThreatIntelligenceFilteringCriteria, fn func(*insights.ThreatIntelligenceIndicatorModel) error) error {
+	if criteria.PageSize == nil {
+		criteria.PageSize = to.Ptr[int32](sentinelInventoryPageSize)
+	}
+
+	pager := tiClient.NewQueryIndicatorsPager(s.creds.ResourceGroup, s.creds.WorkspaceName, criteria, nil)
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("could not query TI indicators: %v", err)
+		}
+
+		for _, value := range page.Value {
+			indicator, ok := value.(*insights.ThreatIntelligenceIndicatorModel)
+			if !ok || indicator.Name == nil {
+				continue
+			}
+
+			if err := fn(indicator); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sourceInventory returns every Sentinel indicator of the source by name, and the names by external ID.
+func (s *Sentinel) sourceInventory(ctx context.Context, tiClient *insights.ThreatIntelligenceIndicatorClient, source string) (map[string]*insights.ThreatIntelligenceIndicatorModel, map[string]string, error) {
+	inventory := make(map[string]*insights.ThreatIntelligenceIndicatorModel)
+	byExternalID := make(map[string]string)
+
+	if err := s.walkIndicators(ctx, tiClient, insights.ThreatIntelligenceFilteringCriteria{
+		IncludeDisabled: to.Ptr(true),
+		Sources:         []*string{to.Ptr(source)},
+	}, func(model *insights.ThreatIntelligenceIndicatorModel) error {
+		if model.Properties == nil || !strings.EqualFold(stringValue(model.Properties.Source), source) {
+			return nil
+		}
+
+		inventory[*model.Name] = model
+
+		if externalID := stringValue(model.Properties.ExternalID); externalID != "" {
+			byExternalID[externalID] = *model.Name
+		}
+
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	return inventory, byExternalID, nil
+}
+
+// existingIndicators finds the Sentinel indicators that items were pushed as before, for items without sync state.
+// The inventory of the source is only fetched once per run, and only when an item without sync state comes by.
+type existingIndicators struct {
+	sentinel *Sentinel
+	tiClient *insights.ThreatIntelligenceIndicatorClient
+	source   string
+
+	byExternalID map[string]string
+}
+
+// lookup returns the name of the Sentinel indicator with the external ID of the item, or an empty string.
+func (e *existingIndicators) lookup(ctx context.Context, item tiItem) (string, error) {
+	if e.byExternalID == nil {
+		_, byExternalID, err := e.sentinel.sourceInventory(ctx, e.tiClient, e.source)
+		if err != nil {
+			return "", err
+		}
+
+		e.byExternalID = byExternalID
+	}
+
+	return matchExternalID(item, e.byExternalID), nil
+}
+
+// InventoryIndicator is a Sentinel indicator as listed by Inventory.
+type InventoryIndicator struct {
+	Name        string
+	ExternalID  string
+	DisplayName string
+	ValidUntil  string
+	Revoked     bool
+}
+
+// Inventory calls fn for every Sentinel indicator of the source, revoked indicators are only included when asked.
+func (s *Sentinel) Inventory(ctx context.Context, source string, includeRevoked bool, fn func(InventoryIndicator) error) error {
+	tiClient, err := s.newTIClient()
+	if err != nil {
+		return err
+	}
+
+	return s.walkIndicators(ctx, tiClient, insights.ThreatIntelligenceFilteringCriteria{
+		IncludeDisabled: to.Ptr(includeRevoked),
+		Sources:         []*string{to.Ptr(source)},
+	}, func(model *insights.ThreatIntelligenceIndicatorModel) error {
+		if model.Properties == nil || !strings.EqualFold(stringValue(model.Properties.Source), source) {
+			return nil
+		}
+
+		revoked := model.Properties.Revoked != nil && *model.Properties.Revoked
+		if revoked && !includeRevoked {
+			return nil
+		}
+
+		return fn(InventoryIndicator{
+			Name:        *model.Name,
+			ExternalID:  stringValue(model.Properties.ExternalID),
+			DisplayName: stringValue(model.Properties.DisplayName),
+			ValidUntil:  stringValue(model.Properties.ValidUntil),
+			Revoked:     revoked,
+		})
+	})
+}
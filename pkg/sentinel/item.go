@@ -0,0 +1,158 @@
+package sentinel
+
+import (
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/metrics"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// tiItem is an indicator as it is pushed into Sentinel.
+type tiItem struct {
+	ExternalID string
+	UUID       string
+	EventUUID  string
+	// Object is set when the item represents a composite indicator such as a whole MISP object
+	Object bool
+
+	Name          string
+	Description   string
+	IndicatorType string
+	ThreatType    string
+	Labels        []string
+	MarkingRefs   []string
+	Confidence    int
+	KillChain     []killChainPhase
+	References    []externalReference
+	Pattern       *stixPattern
+
+	Modified   time.Time
+	ValidUntil time.Time
+	Revoked    bool
+
+	// Hash fingerprints the source contents and derived confidence so unchanged items can be skipped
+	Hash string
+}
+
+// newItem maps an indicator, the error explains why it cannot be exported.
+func (s *Sentinel) newItem(ind indicator.Indicator, today time.Time) (tiItem, error) {
+	var pattern *stixPattern
+	var err error
+
+	if ind.Composite {
+		pattern, err = newCompositePattern(ind.Type, ind.Observables)
+	} else {
+		pattern, err = newPattern(ind.Type, ind.Value())
+	}
+	if err != nil {
+		return tiItem{}, err
+	}
+
+	modified, validUntil := s.validity(ind, today)
+	confidence := s.opts.Confidence.confidence(ind)
+
+	item := tiItem{
+		ExternalID:    ind.ID,
+		UUID:          ind.UUID,
+		EventUUID:     ind.Group.UUID,
+		Object:        ind.Composite,
+		Description:   ind.Description,
+		IndicatorType: ind.Type,
+		MarkingRefs:   markingRefs(ind.Tags),
+		Confidence:    confidence,
+		KillChain:     galaxyKillChainPhases(ind.Clusters),
+		References:    s.eventReferences(ind),
+		Pattern:       pattern,
+		Modified:      modified,
+		ValidUntil:    validUntil,
+		Revoked:       ind.Deleted,
+		ThreatType:    indicatorThreatType(ind),
+		Hash:          indicator.ContentHash(hashedItem{Content: ind.Hash, Confidence: confidence}),
+	}
+
+	if ind.Composite {
+		item.Name = ind.Type + ": " + pattern.groups[0][0].value
+		item.Labels = []string{
+			"info:" + ind.Group.Info,
+			"object:" + ind.Type,
+			"meta-category:" + ind.Category,
+		}
+	} else {
+		item.Name = ind.Category + ": " + ind.Value()
+		item.Labels = []string{
+			"info:" + ind.Group.Info,
+			"category:" + ind.Category,
+			"type:" + ind.Type,
+		}
+	}
+
+	item.Labels = append(item.Labels, galaxyLabels(ind.Clusters)...)
+
+	return item, nil
+}
+
+// newItems maps all indicators, logging and skipping the ones that cannot be exported.
+func (s *Sentinel) newItems(logger *logrus.Entry, indicators []indicator.Indicator, today time.Time) []tiItem {
+	items := make([]tiItem, 0, len(indicators))
+
+	for _, ind := range indicators {
+		item, err := s.newItem(ind, today)
+		if err != nil {
+			logger.WithError(err).WithField("id", ind.ID).WithField("type", ind.Type).
+				Warn("skipping indicator without STIX pattern")
+			metrics.MISPAttributesFiltered.WithLabelValues(metrics.FilteredUnsupported).Inc()
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// validity returns when the indicator was last modified and until when it should be considered valid.
+// Indicators without a last seen time expire relative to their last modification, so the result is stable across runs.
+func (s *Sentinel) validity(ind indicator.Indicator, today time.Time) (time.Time, time.Time) {
+	modified := ind.Modified
+	if modified.IsZero() {
+		modified = today
+	}
+
+	lastSeen := ind.LastSeen
+	if lastSeen.IsZero() {
+		lastSeen = modified
+	}
+
+	return modified, lastSeen.AddDate(0, int(s.opts.ExpireMonths), 0)
+}
+
+// hashedItem is what is fingerprinted of an item, the source contents and the derived confidence.
+type hashedItem struct {
+	Content    interface{}
+	Confidence int
+}
+
+// indicatorThreatType returns the Sentinel threat type of a single or composite indicator.
+func indicatorThreatType(ind indicator.Indicator) string {
+	if ind.Composite {
+		return getObjectThreatType(ind.Type)
+	}
+
+	return getThreatType(ind.Type)
+}
+
+func getObjectThreatType(objectName string) string {
+	switch strings.ToLower(objectName) {
+	case "file":
+		return threatTypeFile
+	case "domain-ip":
+		return threatTypeDomain
+	case "url":
+		return threatTypeURL
+	case "email":
+		return threatTypeEmail
+	default:
+		return "Other"
+	}
+}
@@ -0,0 +1,113 @@
+package sentinel
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"strings"
+)
+
+const (
+	// papDefinitionURL is the FIRST PAP standard, every PAP level is identified by this URL with the level as fragment
+	papDefinitionURL = "https://www.first.org/pap/"
+	// papMarkingsCreated keeps the PAP marking definitions the same on every run. FIRST publishes no STIX definitions for PAP,
+	// so there is no official created time; they take the created time of the OASIS TLP 2.0 definitions they are used next to.
+	papMarkingsCreated = "2022-10-01T00:00:00.000Z"
+)
+
+// tlpMarkings are the OASIS STIX 2.1 marking definitions of the TLP 2.0 levels, TLP:WHITE tags map onto TLP:CLEAR.
+var tlpMarkings = map[string]string{
+	"clear":        "marking-definition--94868c89-83c2-464b-929b-a1a8aa3c8487",
+	"green":        "marking-definition--bab4a63c-aed9-4cf5-a766-dfca5abac2bb",
+	"amber":        "marking-definition--55d920b0-5e8b-4f79-9ee9-91f868d9b421",
+	"amber+strict": "marking-definition--939a9414-2ddd-4d32-a0cd-375ea402b003",
+	"red":          "marking-definition--e828b379-4e03-4974-9ac4-e53a884c97c1",
+}
+
+// papMarkings are the marking definitions of the PAP levels, there are no published STIX definitions for PAP.
+// Their IDs are UUIDv5 in the URL namespace of the level URL, e.g. https://www.first.org/pap/#amber,
+// and PAPMarkingDefinitions returns the matching marking definition objects.
+var papMarkings = newPAPMarkings()
+
+func newPAPMarkings() map[string]string {
+	markings := make(map[string]string, len(indicator.PAPLevels))
+
+	for _, level := range indicator.PAPLevels {
+		markings[level] = "marking-definition--" + uuid.NewSHA1(uuid.NameSpaceURL, []byte(papLevelURL(level))).String()
+	}
+
+	return markings
+}
+
+func papLevelURL(level string) string {
+	return papDefinitionURL + "#" + level
+}
+
+// stixMarkingDefinition is a STIX 2.1 statement marking definition.
+type stixMarkingDefinition struct {
+	Type               string              `json:"type"`
+	SpecVersion        string              `json:"spec_version"`
+	ID                 string              `json:"id"`
+	Created            string              `json:"created"`
+	Name               string              `json:"name"`
+	DefinitionType     string              `json:"definition_type"`
+	Definition         map[string]string   `json:"definition"`
+	ExternalReferences []externalReference `json:"external_references"`
+}
+
+type stixBundle struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id"`
+	Objects []interface{} `json:"objects"`
+}
+
+// PAPMarkingDefinitions returns a STIX 2.1 bundle with the marking definitions the PAP marking references point to.
+func PAPMarkingDefinitions() ([]byte, error) {
+	bundle := stixBundle{
+		Type:    "bundle",
+		ID:      "bundle--" + uuid.NewSHA1(uuid.NameSpaceURL, []byte(papDefinitionURL)).String(),
+		Objects: make([]interface{}, 0, len(indicator.PAPLevels)),
+	}
+
+	for _, level := range indicator.PAPLevels {
+		name := "PAP:" + strings.ToUpper(level)
+
+		bundle.Objects = append(bundle.Objects, stixMarkingDefinition{
+			Type:           "marking-definition",
+			SpecVersion:    "2.1",
+			ID:             papMarkings[level],
+			Created:        papMarkingsCreated,
+			Name:           name,
+			DefinitionType: "statement",
+			Definition:     map[string]string{"statement": name},
+			ExternalReferences: []externalReference{{
+				SourceName:  "FIRST",
+				Description: "Permissible Actions Protocol (PAP)",
+				URL:         papLevelURL(level),
+			}},
+		})
+	}
+
+	raw, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("could not encode PAP marking definitions: %v", err)
+	}
+
+	return raw, nil
+}
+
+// markingRefs returns the marking definition references for the TLP and PAP tags.
+func markingRefs(tags []string) []string {
+	refs := make([]string, 0, 2)
+
+	if ref, ok := tlpMarkings[indicator.TLP(tags)]; ok {
+		refs = append(refs, ref)
+	}
+
+	if ref, ok := papMarkings[indicator.PAP(tags)]; ok {
+		refs = append(refs, ref)
+	}
+
+	return refs
+}
@@ -0,0 +1,88 @@
+package sentinel
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTLPMarkings(t *testing.T) {
+	// the OASIS TLP 2.0 marking definitions
+	want := map[string]string{
+		"clear":        "marking-definition--94868c89-83c2-464b-929b-a1a8aa3c8487",
+		"green":        "marking-definition--bab4a63c-aed9-4cf5-a766-dfca5abac2bb",
+		"amber":        "marking-definition--55d920b0-5e8b-4f79-9ee9-91f868d9b421",
+		"amber+strict": "marking-definition--939a9414-2ddd-4d32-a0cd-375ea402b003",
+		"red":          "marking-definition--e828b379-4e03-4974-9ac4-e53a884c97c1",
+	}
+
+	if !reflect.DeepEqual(tlpMarkings, want) {
+		t.Errorf("tlpMarkings = %v, want %v", tlpMarkings, want)
+	}
+}
+
+func TestPAPMarkings(t *testing.T) {
+	// indicators that were pushed before keep referencing these definitions
+	want := map[string]string{
+		"clear": "marking-definition--379d9ccd-9b73-58fd-997c-6be32ced0f35",
+		"green": "marking-definition--7768cabb-9260-5dc7-9954-b27744ecaada",
+		"amber": "marking-definition--fa8aa14a-a193-5fc0-9e4f-e4d07b72168f",
+		"red":   "marking-definition--d949660c-3a8e-59c1-a0b0-f2a464a4c095",
+	}
+
+	for level, id := range want {
+		if papMarkings[level] != id {
+			t.Errorf("PAP:%s marking = %s, want %s", level, papMarkings[level], id)
+		}
+	}
+
+	raw, err := PAPMarkingDefinitions()
+	if err != nil {
+		t.Fatalf("PAPMarkingDefinitions() error = %v", err)
+	}
+
+	var bundle struct {
+		Type    string                  `json:"type"`
+		Objects []stixMarkingDefinition `json:"objects"`
+	}
+	if err := json.Unmarshal(raw, &bundle); err != nil {
+		t.Fatalf("could not decode bundle: %v", err)
+	}
+
+	defined := make(map[string]bool, len(bundle.Objects))
+	for _, definition := range bundle.Objects {
+		if definition.Type != "marking-definition" || definition.Definition["statement"] == "" || definition.Created != papMarkingsCreated {
+			t.Errorf("invalid marking definition %+v", definition)
+		}
+
+		defined[definition.ID] = true
+	}
+
+	for level, id := range papMarkings {
+		if !defined[id] {
+			t.Errorf("no marking definition for PAP:%s reference %s", level, id)
+		}
+	}
+}
+
+func TestMarkingRefs(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+	}{
+		{name: "none", tags: []string{"malware"}, want: []string{}},
+		{name: "tlp 1.0 white", tags: []string{"tlp:white"}, want: []string{tlpMarkings["clear"]}},
+		{name: "most restrictive tlp", tags: []string{"tlp:green", "TLP:AMBER"}, want: []string{tlpMarkings["amber"]}},
+		{name: "tlp and pap", tags: []string{"tlp:red", "PAP:GREEN"}, want: []string{tlpMarkings["red"], papMarkings["green"]}},
+		{name: "pap only", tags: []string{"pap:amber"}, want: []string{papMarkings["amber"]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := markingRefs(tt.tags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("markingRefs(%v) = %v, want %v", tt.tags, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package sentinel
+
+import (
+	"github.com/hazcod/crowdstrike2sentinel/pkg/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// actionLabels are the metric labels of the plan actions.
+var actionLabels = map[string]string{
+	PlanCreate:    "created",
+	PlanUpdate:    "updated",
+	PlanUnchanged: "skipped",
+	PlanRevoke:    "revoked",
+	PlanDelete:    "deleted",
+}
+
+// countChange counts a change that was made in Sentinel.
+func countChange(change PlanChange) {
+	threatType := change.ThreatType
+	if threatType == "" {
+		threatType = "unknown"
+	}
+
+	metrics.Indicators.WithLabelValues(actionLabels[change.Action], threatType).Inc()
+}
+
+// observeRequest records the latency of an Azure request, the code is "error" when no response was received.
+func observeRequest(method string, resp *http.Response, start time.Time) {
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+
+	metrics.AzureRequestDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+}
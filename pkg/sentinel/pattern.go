@@ -0,0 +1,383 @@
+package sentinel
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/securityinsights/armsecurityinsights/v2"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	stixPatternType    = "stix"
+	stixPatternVersion = "2.1"
+)
+
+// stixComparison is a single comparison expression of a STIX pattern, e.g. ipv4-addr:value = '1.2.3.4'.
+type stixComparison struct {
+	objectType string
+	property   string
+	value      string
+	// numeric values are not quoted in the pattern
+	numeric bool
+}
+
+func (c stixComparison) String() string {
+	value := c.value
+	if !c.numeric {
+		value = "'" + escapeSTIXString(value) + "'"
+	}
+
+	return fmt.Sprintf("%s:%s = %s", c.objectType, c.property, value)
+}
+
+// stixPattern is a single observation expression that matches when all its groups match,
+// a group matches when any of its comparisons match.
+type stixPattern struct {
+	groups [][]stixComparison
+}
+
+// newANDPattern returns a pattern that matches when all the comparisons match.
+func newANDPattern(comparisons ...stixComparison) *stixPattern {
+	pattern := stixPattern{groups: make([][]stixComparison, 0, len(comparisons))}
+	for _, comparison := range comparisons {
+		pattern.groups = append(pattern.groups, []stixComparison{comparison})
+	}
+
+	return &pattern
+}
+
+func (p *stixPattern) String() string {
+	expressions := make([]string, 0, len(p.groups))
+
+	for _, group := range p.groups {
+		alternatives := make([]string, 0, len(group))
+		for _, comparison := range group {
+			alternatives = append(alternatives, comparison.String())
+		}
+
+		expression := strings.Join(alternatives, " OR ")
+		if len(group) > 1 && len(p.groups) > 1 {
+			expression = "(" + expression + ")"
+		}
+
+		expressions = append(expressions, expression)
+	}
+
+	return "[" + strings.Join(expressions, " AND ") + "]"
+}
+
+// Parsed returns the pattern in the pre-parsed form Sentinel stores alongside it.
+func (p *stixPattern) Parsed() []*insights.ThreatIntelligenceParsedPattern {
+	parsed := make([]*insights.ThreatIntelligenceParsedPattern, 0)
+	byObjectType := make(map[string]*insights.ThreatIntelligenceParsedPattern)
+
+	for _, comparison := range p.comparisons() {
+		entry, ok := byObjectType[comparison.objectType]
+		if !ok {
+			entry = &insights.ThreatIntelligenceParsedPattern{PatternTypeKey: to.Ptr(comparison.objectType)}
+			byObjectType[comparison.objectType] = entry
+			parsed = append(parsed, entry)
+		}
+
+		entry.PatternTypeValues = append(entry.PatternTypeValues, &insights.ThreatIntelligenceParsedPatternTypeValue{
+			ValueType: to.Ptr(comparison.property),
+			Value:     to.Ptr(comparison.value),
+		})
+	}
+
+	return parsed
+}
+
+func (p *stixPattern) comparisons() []stixComparison {
+	comparisons := make([]stixComparison, 0)
+	for _, group := range p.groups {
+		comparisons = append(comparisons, group...)
+	}
+
+	return comparisons
+}
+
+// escapeSTIXString escapes a value for use inside a quoted STIX string literal.
+func escapeSTIXString(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
+
+// newPattern builds the STIX 2.1 pattern that matches the given MISP attribute value.
+// Composite attribute types such as filename|sha256 keep every part in a single observation.
+func newPattern(attributeType, value string) (*stixPattern, error) {
+	if strings.Contains(attributeType, "|") {
+		comparisons, err := newCompositeComparisons(attributeType, value)
+		if err != nil {
+			return nil, err
+		}
+
+		return newANDPattern(comparisons...), nil
+	}
+
+	comparison, err := newComparison(attributeType, strings.TrimSpace(value))
+	if err != nil {
+		return nil, err
+	}
+
+	return newANDPattern(comparison), nil
+}
+
+// newCompositeComparisons splits a composite MISP attribute and relates its parts to each other.
+func newCompositeComparisons(attributeType, value string) ([]stixComparison, error) {
+	types := strings.Split(strings.ToLower(attributeType), "|")
+	values := strings.SplitN(value, "|", len(types))
+
+	if len(types) != 2 || len(values) != 2 {
+		return nil, fmt.Errorf("invalid composite value for attribute type %s", attributeType)
+	}
+
+	first, second := strings.TrimSpace(values[0]), strings.TrimSpace(values[1])
+	if first == "" || second == "" {
+		return nil, fmt.Errorf("empty composite value part for attribute type %s", attributeType)
+	}
+
+	switch {
+	case types[0] == "filename":
+		// filename|md5, filename|sha256, ...
+		hash, err := newComparison(types[1], second)
+		if err != nil {
+			return nil, err
+		}
+
+		if hash.objectType != "file" {
+			return nil, fmt.Errorf("unsupported composite attribute type: %s", attributeType)
+		}
+
+		return []stixComparison{{objectType: "file", property: "name", value: first}, hash}, nil
+
+	case types[0] == "domain" && types[1] == "ip":
+		if net.ParseIP(second) == nil {
+			return nil, fmt.Errorf("invalid IP address '%s'", second)
+		}
+
+		return []stixComparison{
+			{objectType: "domain-name", property: "value", value: first},
+			{objectType: "domain-name", property: "resolves_to_refs[*].value", value: second},
+		}, nil
+
+	case types[1] == "port" && (types[0] == "ip-dst" || types[0] == "ip-src" || types[0] == "hostname"):
+		if _, err := strconv.ParseUint(second, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid port '%s'", second)
+		}
+
+		if types[0] != "hostname" && net.ParseIP(first) == nil {
+			return nil, fmt.Errorf("invalid IP address '%s'", first)
+		}
+
+		direction := "dst"
+		if types[0] == "ip-src" {
+			direction = "src"
+		}
+
+		return []stixComparison{
+			{objectType: "network-traffic", property: direction + "_ref.value", value: first},
+			{objectType: "network-traffic", property: direction + "_port", value: second, numeric: true},
+		}, nil
+
+	case types[0] == "regkey" && types[1] == "value":
+		return []stixComparison{
+			{objectType: "windows-registry-key", property: "key", value: first},
+			{objectType: "windows-registry-key", property: "values[*].data", value: second},
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported composite attribute type: %s", attributeType)
+	}
+}
+
+func newComparison(attributeType, value string) (stixComparison, error) {
+	if value == "" {
+		return stixComparison{}, fmt.Errorf("empty value for attribute type %s", attributeType)
+	}
+
+	switch strings.ToLower(attributeType) {
+	case "ip-dst", "ip-src":
+		return ipComparison(value)
+	case "hostname", "domain":
+		return stixComparison{objectType: "domain-name", property: "value", value: value}, nil
+	case "url", "uri":
+		return stixComparison{objectType: "url", property: "value", value: value}, nil
+	case "email", "email-src", "email-dst":
+		return stixComparison{objectType: "email-addr", property: "value", value: value}, nil
+	case "email-subject":
+		return stixComparison{objectType: "email-message", property: "subject", value: value}, nil
+	case "mac-address":
+		return stixComparison{objectType: "mac-addr", property: "value", value: strings.ToLower(value)}, nil
+	case "filename", "attachment":
+		return stixComparison{objectType: "file", property: "name", value: value}, nil
+	case "md5":
+		return hashComparison("MD5", value), nil
+	case "sha1":
+		return hashComparison("SHA-1", value), nil
+	case "sha256":
+		return hashComparison("SHA-256", value), nil
+	case "sha512":
+		return hashComparison("SHA-512", value), nil
+	case "ssdeep":
+		return hashComparison("SSDEEP", value), nil
+	case "tlsh":
+		return hashComparison("TLSH", value), nil
+	case "vhash":
+		return hashComparison("VHASH", value), nil
+	case "regkey":
+		return stixComparison{objectType: "windows-registry-key", property: "key", value: value}, nil
+	case "user-agent":
+		return stixComparison{objectType: "network-traffic", property: "extensions.'http-request-ext'.request_header.'User-Agent'", value: value}, nil
+	case "as":
+		number := strings.TrimPrefix(strings.ToUpper(value), "AS")
+		if _, err := strconv.ParseUint(number, 10, 32); err != nil {
+			return stixComparison{}, fmt.Errorf("invalid AS number '%s'", value)
+		}
+
+		return stixComparison{objectType: "autonomous-system", property: "number", value: number, numeric: true}, nil
+	default:
+		return stixComparison{}, fmt.Errorf("unsupported attribute type for STIX pattern: %s", attributeType)
+	}
+}
+
+func hashComparison(algorithm, value string) stixComparison {
+	return stixComparison{objectType: "file", property: "hashes.'" + algorithm + "'", value: strings.ToLower(value)}
+}
+
+// ipComparison picks the IPv4 or IPv6 object type for an address or CIDR range.
+func ipComparison(value string) (stixComparison, error) {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		cidrIP, _, err := net.ParseCIDR(value)
+		if err != nil {
+			return stixComparison{}, fmt.Errorf("invalid IP address '%s'", value)
+		}
+
+		ip = cidrIP
+	}
+
+	if ip.To4() != nil {
+		return stixComparison{objectType: "ipv4-addr", property: "value", value: value}, nil
+	}
+
+	return stixComparison{objectType: "ipv6-addr", property: "value", value: value}, nil
+}
+
+// newCompositePattern builds a single pattern over the observables of a composite indicator such as a MISP object.
+// Alternatives of the same observable are OR'ed, different observables are AND'ed.
+func newCompositePattern(compositeType string, observables []indicator.Observable) (*stixPattern, error) {
+	values := make(map[string][]string)
+	for _, observable := range observables {
+		relation := strings.ToLower(observable.Relation)
+		if relation == "" {
+			relation = strings.ToLower(observable.Type)
+		}
+
+		if value := strings.TrimSpace(observable.Value); value != "" {
+			values[relation] = append(values[relation], value)
+		}
+	}
+
+	// sort the values so the pattern is stable across runs
+	for relation := range values {
+		sort.Strings(values[relation])
+	}
+
+	group := func(relations []string, build func(relation, value string) (stixComparison, error)) ([]stixComparison, error) {
+		comparisons := make([]stixComparison, 0)
+		for _, relation := range relations {
+			for _, value := range values[relation] {
+				comparison, err := build(relation, value)
+				if err != nil {
+					return nil, err
+				}
+
+				comparisons = append(comparisons, comparison)
+			}
+		}
+
+		return comparisons, nil
+	}
+
+	groups := make([][]stixComparison, 0)
+	addGroup := func(comparisons []stixComparison, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if len(comparisons) > 0 {
+			groups = append(groups, comparisons)
+		}
+
+		return nil
+	}
+
+	var err error
+
+	switch strings.ToLower(compositeType) {
+	case "file":
+		// any of the hashes identifies the file, the name is only used when there are no hashes
+		err = addGroup(group([]string{"md5", "sha1", "sha256", "sha512", "ssdeep", "tlsh", "vhash"}, newComparison))
+		if err == nil && len(groups) == 0 {
+			err = addGroup(group([]string{"filename"}, newComparison))
+		}
+
+	case "domain-ip":
+		err = addGroup(group([]string{"domain", "hostname"}, func(_, value string) (stixComparison, error) {
+			return stixComparison{objectType: "domain-name", property: "value", value: value}, nil
+		}))
+		if err != nil {
+			break
+		}
+
+		if len(groups) == 0 {
+			err = addGroup(group([]string{"ip"}, func(_, value string) (stixComparison, error) {
+				return ipComparison(value)
+			}))
+			break
+		}
+
+		err = addGroup(group([]string{"ip"}, func(_, value string) (stixComparison, error) {
+			if net.ParseIP(value) == nil {
+				return stixComparison{}, fmt.Errorf("invalid IP address '%s'", value)
+			}
+
+			return stixComparison{objectType: "domain-name", property: "resolves_to_refs[*].value", value: value}, nil
+		}))
+
+	case "url":
+		err = addGroup(group([]string{"url"}, newComparison))
+
+	case "email":
+		for _, field := range []struct{ relation, property string }{
+			{relation: "from", property: "from_ref.value"},
+			{relation: "reply-to", property: "additional_header_fields.'Reply-To'"},
+			{relation: "subject", property: "subject"},
+			{relation: "attachment", property: "body_multipart[*].body_raw_ref.name"},
+		} {
+			property := field.property
+			if err = addGroup(group([]string{field.relation}, func(_, value string) (stixComparison, error) {
+				return stixComparison{objectType: "email-message", property: property, value: value}, nil
+			})); err != nil {
+				break
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported object type for STIX pattern: %s", compositeType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("object %s has no attributes usable in a STIX pattern", compositeType)
+	}
+
+	return &stixPattern{groups: groups}, nil
+}
@@ -0,0 +1,162 @@
+package sentinel
+
+import (
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"testing"
+)
+
+func TestNewPattern(t *testing.T) {
+	tests := []struct {
+		name          string
+		attributeType string
+		value         string
+		want          string
+		wantErr       bool
+	}{
+		{name: "ipv4", attributeType: "ip-dst", value: "1.2.3.4", want: "[ipv4-addr:value = '1.2.3.4']"},
+		{name: "ipv6 cidr", attributeType: "ip-src", value: "2001:db8::/32", want: "[ipv6-addr:value = '2001:db8::/32']"},
+		{name: "trimmed domain", attributeType: "domain", value: " example.com ", want: "[domain-name:value = 'example.com']"},
+		{name: "sha256 lowercased", attributeType: "sha256", value: "ABCDEF", want: "[file:hashes.'SHA-256' = 'abcdef']"},
+		{name: "escaped quote", attributeType: "url", value: `http://x/'a\b`, want: `[url:value = 'http://x/\'a\\b']`},
+		{name: "as number", attributeType: "AS", value: "as13335", want: "[autonomous-system:number = 13335]"},
+		{
+			name:          "filename and hash",
+			attributeType: "filename|md5",
+			value:         "evil.exe|D41D8CD98F00B204E9800998ECF8427E",
+			want:          "[file:name = 'evil.exe' AND file:hashes.'MD5' = 'd41d8cd98f00b204e9800998ecf8427e']",
+		},
+		{
+			name:          "domain and ip",
+			attributeType: "domain|ip",
+			value:         "example.com|1.2.3.4",
+			want:          "[domain-name:value = 'example.com' AND domain-name:resolves_to_refs[*].value = '1.2.3.4']",
+		},
+		{
+			name:          "ip and port",
+			attributeType: "ip-src|port",
+			value:         "1.2.3.4|8080",
+			want:          "[network-traffic:src_ref.value = '1.2.3.4' AND network-traffic:src_port = 8080]",
+		},
+		{name: "invalid ip", attributeType: "ip-dst", value: "not-an-ip", wantErr: true},
+		{name: "invalid as number", attributeType: "as", value: "ASX", wantErr: true},
+		{name: "empty value", attributeType: "domain", value: " ", wantErr: true},
+		{name: "invalid port", attributeType: "ip-dst|port", value: "1.2.3.4|99999", wantErr: true},
+		{name: "missing composite part", attributeType: "filename|sha1", value: "evil.exe|", wantErr: true},
+		{name: "filename without hash", attributeType: "filename|domain", value: "evil.exe|example.com", wantErr: true},
+		{name: "unsupported type", attributeType: "yara", value: "rule x {}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern, err := newPattern(tt.attributeType, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newPattern() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if got := pattern.String(); got != tt.want {
+				t.Errorf("newPattern() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCompositePattern(t *testing.T) {
+	tests := []struct {
+		name          string
+		compositeType string
+		observables   []indicator.Observable
+		want          string
+		wantErr       bool
+	}{
+		{
+			name:          "file hashes are alternatives",
+			compositeType: "file",
+			observables: []indicator.Observable{
+				{Type: "sha256", Value: "BB", Relation: "sha256"},
+				{Type: "md5", Value: "aa", Relation: "md5"},
+				{Type: "filename", Value: "evil.exe", Relation: "filename"},
+			},
+			want: "[file:hashes.'MD5' = 'aa' OR file:hashes.'SHA-256' = 'bb']",
+		},
+		{
+			name:          "file name without hashes",
+			compositeType: "file",
+			observables:   []indicator.Observable{{Type: "filename", Value: "evil.exe", Relation: "filename"}},
+			want:          "[file:name = 'evil.exe']",
+		},
+		{
+			name:          "domain with resolved ips",
+			compositeType: "domain-ip",
+			observables: []indicator.Observable{
+				{Type: "ip-dst", Value: "5.6.7.8", Relation: "ip"},
+				{Type: "domain", Value: "example.com", Relation: "domain"},
+				{Type: "ip-dst", Value: "1.2.3.4", Relation: "ip"},
+			},
+			want: "[domain-name:value = 'example.com' AND (domain-name:resolves_to_refs[*].value = '1.2.3.4' OR domain-name:resolves_to_refs[*].value = '5.6.7.8')]",
+		},
+		{
+			name:          "ip only",
+			compositeType: "domain-ip",
+			observables:   []indicator.Observable{{Type: "ip-dst", Value: "1.2.3.4", Relation: "ip"}},
+			want:          "[ipv4-addr:value = '1.2.3.4']",
+		},
+		{
+			name:          "email",
+			compositeType: "email",
+			observables: []indicator.Observable{
+				{Type: "email-src", Value: "a@example.com", Relation: "from"},
+				{Type: "email-subject", Value: "invoice", Relation: "subject"},
+			},
+			want: "[email-message:from_ref.value = 'a@example.com' AND email-message:subject = 'invoice']",
+		},
+		{
+			name:          "no usable attributes",
+			compositeType: "url",
+			observables:   []indicator.Observable{{Type: "text", Value: "x", Relation: "comment"}},
+			wantErr:       true,
+		},
+		{
+			name:          "unsupported object",
+			compositeType: "person",
+			observables:   []indicator.Observable{{Type: "text", Value: "x", Relation: "name"}},
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern, err := newCompositePattern(tt.compositeType, tt.observables)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newCompositePattern() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if got := pattern.String(); got != tt.want {
+				t.Errorf("newCompositePattern() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatternParsed(t *testing.T) {
+	pattern, err := newPattern("domain|ip", "example.com|1.2.3.4")
+	if err != nil {
+		t.Fatalf("newPattern() error = %v", err)
+	}
+
+	parsed := pattern.Parsed()
+	if len(parsed) != 1 || *parsed[0].PatternTypeKey != "domain-name" {
+		t.Fatalf("Parsed() grouped by %d object types, want only domain-name", len(parsed))
+	}
+
+	if values := parsed[0].PatternTypeValues; len(values) != 2 || *values[1].Value != "1.2.3.4" {
+		t.Errorf("Parsed() did not keep both comparisons in order")
+	}
+}
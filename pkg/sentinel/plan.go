@@ -0,0 +1,239 @@
+package sentinel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/securityinsights/armsecurityinsights/v2"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	PlanCreate    = "create"
+	PlanUpdate    = "update"
+	PlanRevoke    = "revoke"
+	PlanDelete    = "delete"
+	PlanUnchanged = "unchanged"
+
+	// reason of the deletes planned by cleanup
+	reasonExpired = "expired"
+)
+
+// Plan lists what a run would change in Sentinel, it can be saved and applied later on.
+type Plan struct {
+	Created time.Time `json:"created"`
+	// Workspace is the workspace the plan was made for, a plan cannot be applied to another workspace
+	Workspace string `json:"workspace"`
+	Source    string `json:"source,omitempty"`
+	// HighWaterMark is the sync high-water mark to move to once the plan is applied
+	HighWaterMark time.Time    `json:"high_water_mark"`
+	Unchanged     int          `json:"unchanged"`
+	Changes       []PlanChange `json:"changes"`
+}
+
+// PlanChange is a single create, update, revoke or delete of a Sentinel indicator.
+type PlanChange struct {
+	Action string `json:"action"`
+	// UUID identifies the source indicator, it is empty for expired indicators without sync state that are cleaned up
+	UUID        string `json:"uuid,omitempty"`
+	ExternalID  string `json:"external_id,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	// IndicatorName is the name of the existing Sentinel indicator
+	IndicatorName string   `json:"indicator_name,omitempty"`
+	Changed       []string `json:"changed,omitempty"`
+	Reason        string   `json:"reason,omitempty"`
+	ThreatType    string   `json:"threat_type,omitempty"`
+
+	// Properties are pushed through the ARM API, STIX through the upload API
+	Properties *insights.ThreatIntelligenceIndicatorProperties `json:"properties,omitempty"`
+	STIX       *stixIndicator                                  `json:"stix,omitempty"`
+
+	// the sync state that is saved once the change is applied
+	EventUUID string `json:"event_uuid,omitempty"`
+	Object    bool   `json:"object,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	// Modified is the source modification time of the item, reported when the change is rejected
+	Modified time.Time `json:"modified,omitempty"`
+}
+
+// newChange returns a change of an item, with the sync state to save once it is applied.
+func newChange(action string, item tiItem) PlanChange {
+	return PlanChange{
+		Action:      action,
+		UUID:        item.UUID,
+		ExternalID:  item.ExternalID,
+		DisplayName: item.Name,
+		ThreatType:  item.ThreatType,
+		EventUUID:   item.EventUUID,
+		Object:      item.Object,
+		Hash:        item.Hash,
+		Modified:    item.Modified,
+	}
+}
+
+// Summary returns the amount of changes per action.
+func (p *Plan) Summary() map[string]int {
+	summary := map[string]int{
+		PlanCreate:    0,
+		PlanUpdate:    0,
+		PlanRevoke:    0,
+		PlanDelete:    0,
+		PlanUnchanged: p.Unchanged,
+	}
+
+	for _, change := range p.Changes {
+		summary[change.Action] += 1
+	}
+
+	return summary
+}
+
+// Save writes the plan as JSON.
+func (p *Plan) Save(path string) error {
+	raw, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode plan: %v", err)
+	}
+
+	if err := os.WriteFile(path, raw, 0600); err != nil {
+		return fmt.Errorf("could not write plan file '%s': %v", path, err)
+	}
+
+	return nil
+}
+
+// LoadPlan reads a plan that was saved earlier.
+func LoadPlan(path string) (*Plan, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read plan file '%s': %v", path, err)
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(raw, &plan); err != nil {
+		return nil, fmt.Errorf("could not decode plan file '%s': %v", path, err)
+	}
+
+	return &plan, nil
+}
+
+// Planner is a Sink that records what a run would change into a plan, without writing to Sentinel or the sync state.
+type Planner struct {
+	sentinel *Sentinel
+
+	mu   sync.Mutex
+	plan Plan
+	// byUUID holds the last change planned per source indicator, a sync may plan the same indicator in several passes
+	byUUID map[string]PlanChange
+}
+
+var _ indicator.Sink = (*Planner)(nil)
+
+// NewPlanner creates a planner for the workspace of this Sentinel instance.
+func (s *Sentinel) NewPlanner() *Planner {
+	return &Planner{
+		sentinel: s,
+		plan: Plan{
+			Created:   time.Now().UTC(),
+			Workspace: s.workspace(),
+			Changes:   make([]PlanChange, 0),
+		},
+		byUUID: make(map[string]PlanChange),
+	}
+}
+
+// SubmitThreatIntel plans the creates and updates of the indicators of the source.
+func (p *Planner) SubmitThreatIntel(ctx context.Context, l *logrus.Logger, source string, pages <-chan []indicator.Indicator) error {
+	p.mu.Lock()
+	p.plan.Source = source
+	p.mu.Unlock()
+
+	return p.sentinel.submitThreatIntel(ctx, l, source, pages, func(_ context.Context, _ *logrus.Entry, changes []PlanChange) error {
+		p.add(changes)
+		return nil
+	})
+}
+
+// RevokeThreatIntel plans the revokes and deletes of retracted indicators.
+func (p *Planner) RevokeThreatIntel(_ context.Context, l *logrus.Logger, _ string, retractions map[string]indicator.Retraction) error {
+	changes, err := p.sentinel.planRetractions(l.WithField("module", "sentinel_revoke"), retractions)
+	if err != nil {
+		return err
+	}
+
+	p.add(changes)
+	return nil
+}
+
+// CleanupThreatIntel plans the deletes of the expired indicators of the given sources.
+func (p *Planner) CleanupThreatIntel(ctx context.Context, l *logrus.Logger, sources []string) error {
+	changes, err := p.sentinel.planCleanup(ctx, l.WithField("module", "sentinel_ti"), sources)
+	if err != nil {
+		return err
+	}
+
+	p.add(changes)
+	return nil
+}
+
+// SetHighWaterMark records the high-water mark to move to once the plan is applied.
+func (p *Planner) SetHighWaterMark(mark time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.plan.HighWaterMark = mark
+}
+
+// Plan returns the recorded plan, with the changes ordered by action and indicator.
+func (p *Planner) Plan() *Plan {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	plan := p.plan
+	plan.Changes = append([]PlanChange(nil), p.plan.Changes...)
+
+	for _, change := range p.byUUID {
+		// unchanged indicators are only counted, applying them would only refresh the sync state
+		if change.Action == PlanUnchanged {
+			plan.Unchanged += 1
+			continue
+		}
+
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	sort.SliceStable(plan.Changes, func(i, j int) bool {
+		if plan.Changes[i].Action != plan.Changes[j].Action {
+			return plan.Changes[i].Action < plan.Changes[j].Action
+		}
+
+		return plan.Changes[i].UUID+plan.Changes[i].IndicatorName < plan.Changes[j].UUID+plan.Changes[j].IndicatorName
+	})
+
+	return &plan
+}
+
+func (p *Planner) add(changes []PlanChange) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, change := range changes {
+		// the last change of an indicator wins, so applying the plan changes it only once
+		if change.UUID != "" {
+			p.byUUID[change.UUID] = change
+			continue
+		}
+
+		if change.Action == PlanUnchanged {
+			p.plan.Unchanged += 1
+			continue
+		}
+
+		p.plan.Changes = append(p.plan.Changes, change)
+	}
+}
@@ -0,0 +1,231 @@
+package sentinel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/sirupsen/logrus"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlanApplyRoundTrip(t *testing.T) {
+	modified := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+
+	domain := newTestIndicator("00000000-0000-0000-0000-0000000000a1", "domain", "example.com", modified.Add(-time.Hour))
+	ip := newTestIndicator("00000000-0000-0000-0000-0000000000a2", "ip-dst", "1.2.3.4", modified)
+
+	tests := []struct {
+		name      string
+		uploadAPI bool
+		reject    []string
+		// wantSaved are the indicators with sync state after the plan was applied
+		wantSaved []string
+		wantMark  time.Time
+		// loseState plans again without the sync state, the indicators are then found by external ID
+		loseState bool
+	}{
+		{
+			name:      "arm api",
+			wantSaved: []string{domain.UUID, ip.UUID},
+			wantMark:  modified,
+		},
+		{
+			name:      "arm api without sync state",
+			wantSaved: []string{domain.UUID, ip.UUID},
+			wantMark:  modified,
+			loseState: true,
+		},
+		{
+			name:      "upload api",
+			uploadAPI: true,
+			wantSaved: []string{domain.UUID, ip.UUID},
+			wantMark:  modified,
+		},
+		{
+			name:      "rejected upload holds back the mark",
+			uploadAPI: true,
+			reject:    []string{stixIndicatorPrefix + domain.UUID},
+			wantSaved: []string{ip.UUID},
+			wantMark:  domain.Modified.Add(-time.Second),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			store := openTestStore(t)
+
+			fc := newFakeCloud(t)
+			for _, id := range tt.reject {
+				fc.reject[id] = true
+			}
+
+			opts := Options{UploadAPI: tt.uploadAPI, ExpireMonths: 6, Workers: 2}
+			s, _ := newTestSentinel(t, fc, store, opts)
+			source := &fakeSource{indicators: []indicator.Indicator{domain, ip}}
+
+			planner := s.NewPlanner()
+
+			latest, err := indicator.Stream(ctx, logger, source, planner, time.Time{})
+			if err != nil {
+				t.Fatalf("could not plan: %v", err)
+			}
+			planner.SetHighWaterMark(latest)
+
+			path := filepath.Join(t.TempDir(), "plan.json")
+			if err := planner.Plan().Save(path); err != nil {
+				t.Fatalf("could not save plan: %v", err)
+			}
+
+			plan, err := LoadPlan(path)
+			if err != nil {
+				t.Fatalf("could not load plan: %v", err)
+			}
+
+			if got := plan.Summary()[PlanCreate]; got != 2 {
+				t.Fatalf("plan creates %d indicators, want 2", got)
+			}
+
+			if len(fc.created) > 0 {
+				t.Fatalf("planning changed Sentinel: %v", fc.created)
+			}
+
+			err = s.ApplyPlan(ctx, logger, plan)
+
+			var rejected *indicator.RejectedError
+			if len(tt.reject) > 0 {
+				if !errors.As(err, &rejected) || rejected.Rejected != len(tt.reject) {
+					t.Fatalf("ApplyPlan() error = %v, want %d rejected", err, len(tt.reject))
+				}
+
+				if held := rejected.Earliest.Add(-time.Second); held.Before(latest) {
+					latest = held
+				}
+			} else if err != nil {
+				t.Fatalf("ApplyPlan() error = %v", err)
+			}
+
+			if !latest.Equal(tt.wantMark) {
+				t.Errorf("high-water mark = %s, want %s", latest, tt.wantMark)
+			}
+
+			if len(fc.created) != len(tt.wantSaved) {
+				t.Errorf("created %d indicators in Sentinel, want %d", len(fc.created), len(tt.wantSaved))
+			}
+
+			for _, uuid := range tt.wantSaved {
+				record, err := store.Get(uuid)
+				if err != nil || record == nil || record.IndicatorName == "" {
+					t.Errorf("no sync state for %s after apply: %v", uuid, err)
+				}
+			}
+
+			if tt.loseState {
+				s, _ = newTestSentinel(t, fc, openTestStore(t), opts)
+			}
+
+			// planning again only leaves what was rejected to do
+			replanner := s.NewPlanner()
+			if _, err := indicator.Stream(ctx, logger, source, replanner, time.Time{}); err != nil {
+				t.Fatalf("could not plan again: %v", err)
+			}
+
+			replan := replanner.Plan()
+			if replan.Unchanged != len(tt.wantSaved) || len(replan.Changes) != len(tt.reject) {
+				t.Errorf("second plan has %d unchanged and %d changes, want %d and %d",
+					replan.Unchanged, len(replan.Changes), len(tt.wantSaved), len(tt.reject))
+			}
+		})
+	}
+}
+
+func TestPlannerPlansIndicatorsOnce(t *testing.T) {
+	modified := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+
+	domain := newTestIndicator("00000000-0000-0000-0000-0000000000a1", "domain", "example.com", modified)
+	ip := newTestIndicator("00000000-0000-0000-0000-0000000000a2", "ip-dst", "1.2.3.4", modified)
+
+	refs := []indicator.Ref{{UUID: domain.UUID}, {UUID: ip.UUID}}
+
+	for _, uploadAPI := range []bool{false, true} {
+		t.Run(fmt.Sprintf("upload api %v", uploadAPI), func(t *testing.T) {
+			ctx := context.Background()
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			fc := newFakeCloud(t)
+			s, _ := newTestSentinel(t, fc, openTestStore(t), Options{UploadAPI: uploadAPI, ExpireMonths: 6, Workers: 2})
+
+			source := &fakeSource{indicators: []indicator.Indicator{domain, ip}}
+			if _, err := indicator.Stream(ctx, logger, source, s, time.Time{}); err != nil {
+				t.Fatalf("could not sync: %v", err)
+			}
+
+			changed := domain
+			changed.Description = "changed"
+			changed.Hash += "changed"
+			changed.Modified = modified.Add(time.Hour)
+			source.indicators = []indicator.Indicator{changed, ip}
+
+			// a dry run sync plans the changes since the last sync and then rechecks every pushed indicator
+			planner := s.NewPlanner()
+			if _, err := indicator.Stream(ctx, logger, source, planner, modified); err != nil {
+				t.Fatalf("could not plan: %v", err)
+			}
+
+			if err := indicator.Recheck(ctx, logger, source, planner, refs); err != nil {
+				t.Fatalf("could not plan the recheck: %v", err)
+			}
+
+			plan := planner.Plan()
+			if summary := plan.Summary(); summary[PlanUpdate] != 1 || summary[PlanUnchanged] != 1 || len(plan.Changes) != 1 {
+				t.Errorf("plan has %d changes and summary %v, want a single update and a single unchanged", len(plan.Changes), summary)
+			}
+		})
+	}
+}
+
+func TestUploadChangesCountsPartial(t *testing.T) {
+	ctx := context.Background()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	modified := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+
+	indicators := make([]indicator.Indicator, 0, uploadMaxBatchSize+50)
+	for i := 0; i < cap(indicators); i++ {
+		uuid := fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+		indicators = append(indicators, newTestIndicator(uuid, "ip-dst", fmt.Sprintf("10.0.%d.%d", i/256, i%256), modified))
+	}
+
+	store := openTestStore(t)
+	fc := newFakeCloud(t)
+	s, _ := newTestSentinel(t, fc, store, Options{UploadAPI: true, ExpireMonths: 6, Workers: 2})
+
+	planner := s.NewPlanner()
+	if _, err := indicator.Stream(ctx, logger, &fakeSource{indicators: indicators}, planner, time.Time{}); err != nil {
+		t.Fatalf("could not plan: %v", err)
+	}
+
+	// the second batch fails, the first one was accepted and is still counted
+	fc.uploadLimit = 1
+
+	uploaded, err := s.uploadChanges(ctx, logger.WithField("test", t.Name()), "MISP", planner.Plan().Changes)
+	if err == nil {
+		t.Fatal("uploadChanges() did not fail")
+	}
+
+	if uploaded[PlanCreate] != uploadMaxBatchSize {
+		t.Errorf("uploadChanges() counted %d created indicators, want %d", uploaded[PlanCreate], uploadMaxBatchSize)
+	}
+}
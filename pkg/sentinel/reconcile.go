@@ -0,0 +1,284 @@
+package sentinel
+
+import (
+	"context"
+	"fmt"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/securityinsights/armsecurityinsights/v2"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReconcileReport lists the differences between the source and the Sentinel TI inventory of that source.
+type ReconcileReport struct {
+	// Missing contains the source indicator UUIDs without a Sentinel indicator.
+	Missing []string
+	// Stale contains the Sentinel indicator names whose source indicator was retracted or removed.
+	Stale []string
+	// Unverified contains the Sentinel indicator names outside the fetched window without a sync state to check them by.
+	Unverified []string
+	// Drifted contains the changed properties per Sentinel indicator name.
+	Drifted map[string][]string
+}
+
+// Reconcile diffs every Sentinel indicator of the source against its current indicators.
+// Sentinel indicators without a current indicator may still be valid outside the fetched window, they are only
+// stale when the source confirms they were retracted.
+// When fix is set, missing indicators are created, drifted ones are updated and stale ones are revoked, or deleted when DeleteRevoked is set.
+func (s *Sentinel) Reconcile(ctx context.Context, l *logrus.Logger, src indicator.Source, indicators []indicator.Indicator, fix bool) (*ReconcileReport, error) {
+	logger := l.WithField("module", "sentinel_reconcile")
+	source := src.Name()
+
+	tiClient, err := s.newTIClient()
+	if err != nil {
+		return nil, err
+	}
+
+	inventory, byExternalID, err := s.sourceInventory(ctx, tiClient, source)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.WithField("inventory", len(inventory)).WithField("indicators", len(indicators)).
+		Info("reconciling Sentinel TI inventory")
+
+	report := ReconcileReport{
+		Missing:    make([]string, 0),
+		Stale:      make([]string, 0),
+		Unverified: make([]string, 0),
+		Drifted:    make(map[string][]string),
+	}
+
+	today := time.Now()
+	matched := make(map[string]bool, len(inventory))
+	fixes := make([]PlanChange, 0)
+
+	for _, item := range s.newItems(logger, indicators, today) {
+		if item.ValidUntil.Before(today) {
+			continue
+		}
+
+		name, err := s.matchIndicator(item, inventory, byExternalID)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "" {
+			report.Missing = append(report.Missing, item.UUID)
+			fixes = append(fixes, s.newCreateFix(item, source))
+			continue
+		}
+
+		matched[name] = true
+
+		desired := newIndicatorProperties(item, source)
+
+		if changed := diffIndicator(inventory[name].Properties, desired); len(changed) > 0 {
+			record, err := s.lookupState(item.UUID)
+			if err != nil {
+				return nil, err
+			}
+
+			report.Drifted[name] = changed
+			fixes = append(fixes, newUpdateFix(item, inventory[name], desired, changed, createdTime(record), today))
+		}
+	}
+
+	unmatched := make([]string, 0)
+	for name, model := range inventory {
+		if matched[name] || boolValue(model.Properties.Revoked) {
+			continue
+		}
+
+		unmatched = append(unmatched, name)
+	}
+
+	var retractions map[string]indicator.Retraction
+	report.Stale, report.Unverified, retractions, err = s.confirmStale(ctx, src, inventory, unmatched)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(report.Missing)
+	sort.Strings(report.Stale)
+	sort.Strings(report.Unverified)
+
+	if !fix {
+		return &report, nil
+	}
+
+	// stale indicators are retracted like any other, which keeps the sync state in step
+
+	if len(retractions) > 0 {
+		revocations, err := s.planRetractions(logger, retractions)
+		if err != nil {
+			return nil, err
+		}
+
+		fixes = append(fixes, revocations...)
+	}
+
+	applied, err := s.applyChanges(ctx, logger, source, fixes)
+	if err != nil {
+		return nil, fmt.Errorf("could not fix drift: %v", err)
+	}
+
+	logger.WithField("created", applied[PlanCreate]).WithField("updated", applied[PlanUpdate]).
+		WithField("revoked", applied[PlanRevoke]).WithField("deleted", applied[PlanDelete]).
+		Info("fixed Sentinel TI inventory")
+
+	return &report, nil
+}
+
+// newCreateFix returns the change that creates a missing indicator through the configured submit API.
+func (s *Sentinel) newCreateFix(item tiItem, source string) PlanChange {
+	change := newChange(PlanCreate, item)
+
+	if s.opts.UploadAPI {
+		stix := newSTIXIndicator(item, time.Time{})
+		change.STIX = &stix
+	} else {
+		change.Properties = newIndicatorProperties(item, source)
+	}
+
+	return change
+}
+
+// newUpdateFix returns the change that updates a drifted indicator through the API it was submitted with,
+// uploaded indicators keep the created time of their first revision.
+func newUpdateFix(item tiItem, current *insights.ThreatIntelligenceIndicatorModel, desired *insights.ThreatIntelligenceIndicatorProperties, changed []string, created, today time.Time) PlanChange {
+	change := newChange(PlanUpdate, item)
+	change.IndicatorName = *current.Name
+	change.Changed = changed
+
+	if uploaded(current) {
+		stix := newSTIXIndicator(item, created)
+		// the modified time must increase for Sentinel to accept the new revision
+		stix.Modified = today.UTC().Format(time.RFC3339)
+		change.STIX = &stix
+
+		return change
+	}
+
+	applyIndicatorChanges(current.Properties, desired, changed)
+	change.Properties = current.Properties
+
+	return change
+}
+
+// confirmStale asks the source which of the unmatched Sentinel indicators were retracted, by their sync state.
+// It returns the retracted indicator names and their retractions, and the names that cannot be checked because they have no sync state.
+func (s *Sentinel) confirmStale(ctx context.Context, src indicator.Source, inventory map[string]*insights.ThreatIntelligenceIndicatorModel, names []string) ([]string, []string, map[string]indicator.Retraction, error) {
+	if len(names) == 0 {
+		return nil, nil, nil, nil
+	}
+
+	stateByName, err := s.stateByIndicatorName()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// uploaded indicators are named by Sentinel, their sync state is kept under the STIX ID that Sentinel sets as external ID
+	byName := make(map[string]state.Record, len(names))
+	for _, name := range names {
+		key := name
+		if uploaded(inventory[name]) {
+			key = stringValue(inventory[name].Properties.ExternalID)
+		}
+
+		if record, ok := stateByName[key]; ok {
+			byName[name] = record
+		}
+	}
+
+	refs := make([]indicator.Ref, 0, len(names))
+	unverified := make([]string, 0)
+
+	for _, name := range names {
+		record, ok := byName[name]
+		if !ok {
+			unverified = append(unverified, name)
+			continue
+		}
+
+		refs = append(refs, indicator.Ref{UUID: record.AttributeUUID, Composite: record.Object})
+	}
+
+	if len(refs) == 0 {
+		return nil, unverified, nil, nil
+	}
+
+	retractions, err := src.FetchRetractions(ctx, refs)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("could not fetch retractions: %v", err)
+	}
+
+	stale := make([]string, 0, len(retractions))
+	staleRetractions := make(map[string]indicator.Retraction, len(retractions))
+
+	for _, name := range names {
+		if record, ok := byName[name]; ok {
+			if retraction, retracted := retractions[record.AttributeUUID]; retracted {
+				stale = append(stale, name)
+				staleRetractions[record.AttributeUUID] = retraction
+			}
+		}
+	}
+
+	return stale, unverified, staleRetractions, nil
+}
+
+// matchIndicator finds the inventory indicator for an item, by sync state first and external ID second.
+func (s *Sentinel) matchIndicator(item tiItem, inventory map[string]*insights.ThreatIntelligenceIndicatorModel, byExternalID map[string]string) (string, error) {
+	record, err := s.lookupState(item.UUID)
+	if err != nil {
+		return "", err
+	}
+
+	if record != nil {
+		if _, ok := inventory[record.IndicatorName]; ok {
+			return record.IndicatorName, nil
+		}
+	}
+
+	return matchExternalID(item, byExternalID), nil
+}
+
+// matchExternalID returns the name of the indicator with the external ID of the item, as set by the ARM or the upload API.
+func matchExternalID(item tiItem, byExternalID map[string]string) string {
+	for _, externalID := range []string{item.ExternalID, stixIndicatorPrefix + item.UUID} {
+		if name, ok := byExternalID[externalID]; ok {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// uploaded returns whether the indicator was submitted through the upload API, which sets its STIX ID as external ID.
+func uploaded(model *insights.ThreatIntelligenceIndicatorModel) bool {
+	return model != nil && model.Properties != nil && strings.HasPrefix(stringValue(model.Properties.ExternalID), stixIndicatorPrefix)
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+
+	return *value
+}
+
+func boolValue(value *bool) bool {
+	return value != nil && *value
+}
+
+func firstString(values []*string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return stringValue(values[0])
+}
@@ -0,0 +1,91 @@
+package sentinel
+
+import (
+	"context"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconcileUploadedIndicators(t *testing.T) {
+	modified := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+
+	domain := newTestIndicator("00000000-0000-0000-0000-0000000000a1", "domain", "example.com", modified)
+	ip := newTestIndicator("00000000-0000-0000-0000-0000000000a2", "ip-dst", "1.2.3.4", modified)
+
+	changed := domain
+	changed.Description = "changed"
+
+	tests := []struct {
+		name       string
+		indicators []indicator.Indicator
+		retracted  map[string]bool
+		wantStale  int
+		// wantDrifted is the indicator whose description drifted
+		wantDrifted string
+	}{
+		// drift is fixed with a new revision through the upload API, not with an ARM update
+		{name: "drifted", indicators: []indicator.Indicator{changed, ip}, wantDrifted: stixIndicatorPrefix + domain.UUID},
+		// the sync state of uploaded indicators is found by their external ID
+		{name: "stale", indicators: []indicator.Indicator{ip}, retracted: map[string]bool{domain.UUID: true}, wantStale: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			fc := newFakeCloud(t)
+			s, _ := newTestSentinel(t, fc, openTestStore(t), Options{UploadAPI: true, ExpireMonths: 6, Workers: 2})
+
+			source := &fakeSource{indicators: []indicator.Indicator{domain, ip}}
+			if _, err := indicator.Stream(ctx, logger, source, s, time.Time{}); err != nil {
+				t.Fatalf("could not sync: %v", err)
+			}
+
+			for name := range fc.indicators {
+				if strings.HasPrefix(name, stixIndicatorPrefix) {
+					t.Fatalf("uploaded indicator is named %s, want a GUID", name)
+				}
+			}
+
+			uploads := len(fc.uploads)
+			source.indicators = tt.indicators
+			source.retracted = tt.retracted
+
+			report, err := s.Reconcile(ctx, logger, source, tt.indicators, true)
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			if len(report.Missing) != 0 || len(report.Unverified) != 0 || len(report.Stale) != tt.wantStale {
+				t.Errorf("Reconcile() found %d missing, %d unverified and %d stale, want 0, 0 and %d",
+					len(report.Missing), len(report.Unverified), len(report.Stale), tt.wantStale)
+			}
+
+			for _, request := range fc.armRequests {
+				if strings.HasPrefix(request, "PUT ") {
+					t.Errorf("uploaded indicator was fixed through ARM: %s", request)
+				}
+			}
+
+			if tt.wantDrifted == "" {
+				return
+			}
+
+			name := fc.uploadedName(tt.wantDrifted)
+			if changed := strings.Join(report.Drifted[name], ","); !strings.Contains(changed, "Description") {
+				t.Errorf("Reconcile() drifted %v for %s, want its description", report.Drifted[name], name)
+			}
+
+			if len(fc.uploads) == uploads {
+				t.Error("drift of uploaded indicators was not fixed through the upload API")
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package sentinel
+
+import (
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"strings"
+)
+
+const (
+	referenceSourceMISP = "MISP"
+	referenceSourceLink = "MISP event link"
+)
+
+type externalReference struct {
+	SourceName  string `json:"source_name"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+	ExternalID  string `json:"external_id,omitempty"`
+}
+
+// eventReferences links an indicator back to its MISP event, the indicator itself and the links in the event.
+func (s *Sentinel) eventReferences(ind indicator.Indicator) []externalReference {
+	event := ind.Group
+	references := make([]externalReference, 0, 2+len(event.Links))
+
+	eventURL := ""
+	if s.opts.LinkBaseURL != "" && event.ID != "" {
+		eventURL = fmt.Sprintf("%s/events/view/%s", strings.TrimSuffix(s.opts.LinkBaseURL, "/"), event.ID)
+	}
+
+	itemKind := "attribute"
+	if ind.Composite {
+		itemKind = "object"
+	}
+
+	references = append(references,
+		externalReference{
+			SourceName:  referenceSourceMISP,
+			Description: "MISP event: " + event.Info,
+			URL:         eventURL,
+			ExternalID:  event.UUID,
+		},
+		externalReference{
+			SourceName:  referenceSourceMISP,
+			Description: "MISP " + itemKind,
+			ExternalID:  ind.UUID,
+		},
+	)
+
+	for _, link := range event.Links {
+		references = append(references, externalReference{
+			SourceName: referenceSourceLink,
+			URL:        link,
+		})
+	}
+
+	return references
+}
@@ -0,0 +1,142 @@
+package sentinel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// prefix of indicator names that were submitted through the upload API
+	stixIndicatorPrefix = "indicator--"
+)
+
+// RevokeThreatIntel revokes, or deletes when DeleteRevoked is set, the Sentinel indicators of retracted source indicators.
+// Indicators submitted through the upload API can only be revoked, and only while they still exist in the source.
+// Other indicators that are now marked above the maximum TLP are always deleted.
+func (s *Sentinel) RevokeThreatIntel(ctx context.Context, l *logrus.Logger, source string, retractions map[string]indicator.Retraction) error {
+	logger := l.WithField("module", "sentinel_revoke")
+
+	changes, err := s.planRetractions(logger, retractions)
+	if err != nil {
+		return err
+	}
+
+	applied, err := s.applyChanges(ctx, logger, source, changes)
+
+	// rejected revocations stay unrevoked in the sync state, so the next run retracts them again
+	var rejected *indicator.RejectedError
+	if errors.As(err, &rejected) {
+		logger.WithField("rejected", rejected.Rejected).Warn("revocations were rejected by Sentinel")
+	} else if err != nil {
+		return err
+	}
+
+	logger.WithField("revoked", applied[PlanRevoke]).WithField("deleted", applied[PlanDelete]).
+		Info("processed retracted TI indicators")
+
+	return nil
+}
+
+// planRetractions works out how every retracted indicator that was pushed before is revoked or deleted.
+func (s *Sentinel) planRetractions(logger *logrus.Entry, retractions map[string]indicator.Retraction) ([]PlanChange, error) {
+	if s.store == nil {
+		return nil, errors.New("revoking indicators requires a sync state")
+	}
+
+	uuids := make([]string, 0, len(retractions))
+	for uuid := range retractions {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+
+	today := time.Now()
+	changes := make([]PlanChange, 0)
+
+	for _, uuid := range uuids {
+		retraction := retractions[uuid]
+
+		record, err := s.lookupState(uuid)
+		if err != nil {
+			return nil, err
+		}
+
+		if record == nil || record.Revoked {
+			continue
+		}
+
+		recLogger := logger.WithField("uuid", uuid).WithField("name", record.IndicatorName).
+			WithField("reason", retraction.Reason)
+
+		change := PlanChange{
+			Action:        PlanRevoke,
+			UUID:          uuid,
+			IndicatorName: record.IndicatorName,
+			Reason:        retraction.Reason,
+			EventUUID:     record.EventUUID,
+			Object:        record.Object,
+			Hash:          record.Hash,
+		}
+
+		if retraction.Indicator != nil {
+			change.ThreatType = indicatorThreatType(*retraction.Indicator)
+		}
+
+		if strings.HasPrefix(record.IndicatorName, stixIndicatorPrefix) {
+			if retraction.Indicator == nil {
+				recLogger.Warn("cannot revoke removed indicator through the upload API, it will expire instead")
+				continue
+			}
+
+			item, err := s.newItem(*retraction.Indicator, today)
+			if err != nil {
+				recLogger.WithError(err).Warn("could not build pattern for revoked indicator")
+				continue
+			}
+
+			revocation := newSTIXIndicator(item, record.Created)
+			revocation.Revoked = true
+			// the modified time must increase for Sentinel to accept the new revision
+			revocation.Modified = today.UTC().Format(time.RFC3339)
+
+			change.ExternalID = item.ExternalID
+			change.DisplayName = item.Name
+			change.STIX = &revocation
+		} else if s.opts.DeleteRevoked || retraction.Reason == indicator.RetractionTLP {
+			change.Action = PlanDelete
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// markRevoked records in the sync state that the indicator was revoked.
+func (s *Sentinel) markRevoked(uuid string) error {
+	record, err := s.lookupState(uuid)
+	if err != nil || record == nil {
+		return err
+	}
+
+	record.Revoked = true
+	record.LastPushed = time.Now().UTC()
+
+	if err := s.store.Put(*record); err != nil {
+		return fmt.Errorf("could not save sync state: %v", err)
+	}
+
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
@@ -1,22 +1,112 @@
 package sentinel
 
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/securityinsights/armsecurityinsights/v2"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"strings"
+)
+
 type Credentials struct {
-	TenantID       string
-	ClientID       string
-	ClientSecret   string
+	// AuthMethod is one of the Auth constants, defaults to AuthClientSecret
+	AuthMethod string
+
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+
+	CertificatePath     string
+	CertificatePassword string
+	FederatedTokenFile  string
+
 	SubscriptionID string
 	ResourceGroup  string
 	WorkspaceName  string
+	WorkspaceID    string
+
+	// Cloud is one of the Cloud constants, defaults to CloudPublic
+	Cloud string
+	// AuthorityHost, ARMEndpoint and UploadEndpoint override the endpoints of the cloud
+	AuthorityHost  string
+	ARMEndpoint    string
+	UploadEndpoint string
+}
+
+// Options tune how indicators are mapped onto and pushed as Sentinel indicators.
+type Options struct {
+	// ExpireMonths is how long indicators stay valid after they were last seen
+	ExpireMonths uint16
+	// UploadAPI submits through the batched STIX upload API instead of the ARM API
+	UploadAPI bool
+	// DeleteRevoked deletes retracted and stale indicators instead of revoking them
+	DeleteRevoked bool
+	// Workers is the amount of indicators that are created, updated or deleted concurrently
+	Workers int
+	// MaxRequestsPerSecond caps the request rate, zero or less only backs off when Azure throttles
+	MaxRequestsPerSecond float64
+
+	Confidence ConfidencePolicy
+	// LinkBaseURL is the MISP URL that indicators link back to
+	LinkBaseURL string
 }
 
 type Sentinel struct {
 	creds Credentials
+	cloud sovereignCloud
+	cred  azcore.TokenCredential
+	store *state.Store
+	opts  Options
+
+	// throttle paces the requests of every client, so concurrent workers share the Azure quota
+	throttle *throttle
+	// transport sends the requests of every client and credential, nil uses the default HTTP client
+	transport policy.Transporter
+}
+
+var _ indicator.Sink = (*Sentinel)(nil)
+
+// New creates a Sentinel instance, store may be nil to always push every indicator.
+func New(creds Credentials, store *state.Store, opts Options) (*Sentinel, error) {
+	return newSentinel(creds, store, opts, nil)
 }
 
-func New(creds Credentials) (*Sentinel, error) {
+func newSentinel(creds Credentials, store *state.Store, opts Options, transport policy.Transporter) (*Sentinel, error) {
+	endpoints, err := resolveCloud(creds)
+	if err != nil {
+		return nil, err
+	}
+
 	sentinel := Sentinel{
 		creds: creds,
+		cloud: endpoints,
+		store: store,
+		opts:  opts,
+
+		throttle:  newThrottle(opts.MaxRequestsPerSecond),
+		transport: transport,
+	}
+
+	sentinel.cred, err = newTokenCredential(creds, sentinel.clientOptions(), endpoints.disableInstanceDiscovery)
+	if err != nil {
+		return nil, fmt.Errorf("could not authenticate to MS Sentinel: %v", err)
 	}
 
 	return &sentinel, nil
 }
+
+func (s *Sentinel) newTIClient() (*insights.ThreatIntelligenceIndicatorClient, error) {
+	tiClient, err := insights.NewThreatIntelligenceIndicatorClient(s.creds.SubscriptionID, s.cred, s.armOptions())
+	if err != nil {
+		return nil, fmt.Errorf("could not create TI client: %v", err)
+	}
+
+	return tiClient, nil
+}
+
+// workspace identifies the Sentinel workspace, so plans are only applied to the workspace they were made for.
+func (s *Sentinel) workspace() string {
+	return strings.Join([]string{s.creds.SubscriptionID, s.creds.ResourceGroup, s.creds.WorkspaceName}, "/")
+}
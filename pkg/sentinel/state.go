@@ -0,0 +1,91 @@
+package sentinel
+
+import (
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"time"
+)
+
+// lookupState returns the previously pushed state of a MISP attribute or object, if a state store is configured.
+func (s *Sentinel) lookupState(uuid string) (*state.Record, error) {
+	if s.store == nil || uuid == "" {
+		return nil, nil
+	}
+
+	record, err := s.store.Get(uuid)
+	if err != nil {
+		return nil, fmt.Errorf("could not lookup sync state: %v", err)
+	}
+
+	return record, nil
+}
+
+// saveState records that the change was applied as the given indicator.
+func (s *Sentinel) saveState(change PlanChange, indicatorName string) error {
+	if s.store == nil || change.UUID == "" {
+		return nil
+	}
+
+	record := state.Record{
+		AttributeUUID: change.UUID,
+		EventUUID:     change.EventUUID,
+		Object:        change.Object,
+		IndicatorName: indicatorName,
+		Hash:          change.Hash,
+		LastPushed:    time.Now().UTC(),
+	}
+
+	// uploaded indicators keep the created time of their first revision
+	if change.STIX != nil {
+		if created, err := time.Parse(time.RFC3339, change.STIX.Created); err == nil {
+			record.Created = created
+		}
+	}
+
+	if err := s.store.Put(record); err != nil {
+		return fmt.Errorf("could not save sync state: %v", err)
+	}
+
+	return nil
+}
+
+// createdTime returns when the indicator of the record was first uploaded, or zero when it was not.
+func createdTime(record *state.Record) time.Time {
+	if record == nil {
+		return time.Time{}
+	}
+
+	return record.Created
+}
+
+// stateByIndicatorName returns the sync state records keyed by the Sentinel indicator they were pushed as.
+func (s *Sentinel) stateByIndicatorName() (map[string]state.Record, error) {
+	byName := make(map[string]state.Record)
+	if s.store == nil {
+		return byName, nil
+	}
+
+	if err := s.store.Walk(func(record state.Record) error {
+		if record.IndicatorName != "" {
+			byName[record.IndicatorName] = record
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("could not read sync state: %v", err)
+	}
+
+	return byName, nil
+}
+
+// forgetState drops the sync state of an indicator that no longer exists in Sentinel.
+func (s *Sentinel) forgetState(uuid string) error {
+	if s.store == nil || uuid == "" {
+		return nil
+	}
+
+	if err := s.store.Delete(uuid); err != nil {
+		return fmt.Errorf("could not delete sync state: %v", err)
+	}
+
+	return nil
+}
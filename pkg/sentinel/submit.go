@@ -2,13 +2,12 @@ package sentinel
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/securityinsights/armsecurityinsights/v2"
-	"github.com/hazcod/crowdstrike2sentinel/pkg/misp"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/metrics"
 	"github.com/sirupsen/logrus"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -24,6 +23,15 @@ const (
 func getThreatType(patternType string) string {
 	patternType = strings.ToLower(patternType)
 
+	// composite types are classified by their main observable
+	if parts := strings.Split(patternType, "|"); len(parts) > 1 {
+		if parts[len(parts)-1] == "port" {
+			return threatTypeNetwork
+		}
+
+		patternType = parts[0]
+	}
+
 	switch patternType {
 	case "ip-dst":
 		return threatTypeNetwork
@@ -35,129 +43,291 @@ func getThreatType(patternType string) string {
 		return threatTypeFile
 	case "attachment":
 		return threatTypeEmail
+	case "domain", "hostname":
+		return threatTypeDomain
+	case "url":
+		return threatTypeURL
 	default:
 		return "Other"
 	}
 }
 
-// TODO: migrate to the new uploadIndicators (beta) API
-// https://github.com/Azure/azure-sdk-for-go/issues/20907
+// newIndicatorProperties maps an item onto Sentinel indicator properties.
+func newIndicatorProperties(item tiItem, source string) *insights.ThreatIntelligenceIndicatorProperties {
+	labels := make([]*string, 0, len(item.Labels))
+	for _, label := range item.Labels {
+		labels = append(labels, to.Ptr[string](label))
+	}
 
-func (s *Sentinel) SubmitThreatIntel(ctx context.Context, l *logrus.Logger, expireMonths uint16, mispHostname string, attributes []misp.Attribute) error {
-	logger := l.WithField("module", "sentinel_ti")
+	killChainPhases := make([]*insights.ThreatIntelligenceKillChainPhase, 0, len(item.KillChain))
+	for _, phase := range item.KillChain {
+		killChainPhases = append(killChainPhases, &insights.ThreatIntelligenceKillChainPhase{
+			KillChainName: to.Ptr[string](phase.KillChainName),
+			PhaseName:     to.Ptr[string](phase.PhaseName),
+		})
+	}
 
-	cred, err := azidentity.NewClientSecretCredential(s.creds.TenantID, s.creds.ClientID, s.creds.ClientSecret, nil)
-	if err != nil {
-		return fmt.Errorf("could not authenticate to MS Sentinel: %v", err)
+	references := make([]*insights.ThreatIntelligenceExternalReference, 0, len(item.References))
+	for _, reference := range item.References {
+		references = append(references, &insights.ThreatIntelligenceExternalReference{
+			SourceName:  to.Ptr[string](reference.SourceName),
+			Description: optionalString(reference.Description),
+			URL:         optionalString(reference.URL),
+			ExternalID:  optionalString(reference.ExternalID),
+		})
+	}
+
+	markingRefs := make([]*string, 0, len(item.MarkingRefs))
+	for _, ref := range item.MarkingRefs {
+		markingRefs = append(markingRefs, to.Ptr[string](ref))
+	}
+
+	return &insights.ThreatIntelligenceIndicatorProperties{
+		Confidence:                 to.Ptr[int32](int32(item.Confidence)),
+		Created:                    to.Ptr[string](item.Modified.Format(time.RFC3339)),
+		CreatedByRef:               to.Ptr[string](source),
+		Defanged:                   nil,
+		Description:                to.Ptr[string](item.Description),
+		DisplayName:                to.Ptr[string](item.Name),
+		Extensions:                 nil,
+		ExternalID:                 to.Ptr[string](item.ExternalID),
+		ExternalLastUpdatedTimeUTC: nil,
+		ExternalReferences:         references,
+		GranularMarkings:           nil,
+		IndicatorTypes: []*string{
+			to.Ptr[string](item.IndicatorType),
+		},
+		KillChainPhases:        killChainPhases,
+		Labels:                 labels,
+		Language:               nil,
+		LastUpdatedTimeUTC:     to.Ptr[string](item.Modified.Format(time.RFC3339)),
+		Modified:               to.Ptr[string](item.Modified.Format(time.RFC3339)),
+		ObjectMarkingRefs:      markingRefs,
+		ParsedPattern:          item.Pattern.Parsed(),
+		Pattern:                to.Ptr[string](item.Pattern.String()),
+		PatternType:            to.Ptr[string](stixPatternType),
+		PatternVersion:         to.Ptr[string](stixPatternVersion),
+		Revoked:                to.Ptr[bool](item.Revoked),
+		Source:                 to.Ptr[string](source),
+		ThreatIntelligenceTags: nil,
+		ThreatTypes:            []*string{to.Ptr(item.ThreatType)},
+		ValidFrom:              to.Ptr[string](item.Modified.Format(time.RFC3339)),
+		ValidUntil:             to.Ptr[string](item.ValidUntil.Format(time.RFC3339)),
 	}
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+
+	return to.Ptr[string](value)
+}
+
+// changeHandler applies or records the changes planned for a page of indicators.
+type changeHandler func(ctx context.Context, logger *logrus.Entry, changes []PlanChange) error
+
+// SubmitThreatIntel pushes the pages of indicators of the source into Sentinel through the configured API as they arrive.
+func (s *Sentinel) SubmitThreatIntel(ctx context.Context, l *logrus.Logger, source string, pages <-chan []indicator.Indicator) error {
+	total := make(map[string]int)
+	rejected := &indicator.RejectedError{}
+
+	err := s.submitThreatIntel(ctx, l, source, pages, func(ctx context.Context, logger *logrus.Entry, changes []PlanChange) error {
+		applied, err := s.applyChanges(ctx, logger, source, changes)
+		for action, num := range applied {
+			total[action] += num
+		}
+
+		// keep submitting the other pages, the rejected indicators are reported once all were submitted
+		var pageRejected *indicator.RejectedError
+		if errors.As(err, &pageRejected) {
+			rejected.Add(pageRejected.Rejected, pageRejected.Earliest)
+			return nil
+		}
+
+		return err
+	})
+
+	pushedLogger := l.WithField("module", "sentinel_ti").WithField("created", total[PlanCreate]).WithField("updated", total[PlanUpdate]).
+		WithField("unchanged", total[PlanUnchanged]).WithField("rejected", rejected.Rejected)
 
-	tiClient, err := insights.NewThreatIntelligenceIndicatorClient(s.creds.SubscriptionID, cred, nil)
 	if err != nil {
-		return fmt.Errorf("could not create TI client: %v", err)
+		pushedLogger.Warn("pushed part of the TI indicators into Sentinel")
+		return err
 	}
 
-	today := time.Now()
-	defaultExpiration := time.Now().AddDate(0, int(expireMonths), 0)
+	pushedLogger.Info("pushed TI indicators into Sentinel")
+
+	if rejected.Rejected > 0 {
+		return rejected
+	}
 
-	numCreated := 0
+	return nil
+}
 
-	for i, attribute := range attributes {
-		attrLogger := logger.WithField("attr_id", attribute.ID)
+// submitThreatIntel plans the changes per page of indicators and passes them on to handle.
+// Changes for the upload API are collected until a full batch can be uploaded.
+func (s *Sentinel) submitThreatIntel(ctx context.Context, l *logrus.Logger, source string, pages <-chan []indicator.Indicator, handle changeHandler) error {
+	logger := l.WithField("module", "sentinel_ti")
 
-		attrLogger.WithField("num", i).WithField("value", attribute.Value).
-			Debug("pushing TI indicator")
+	var tiClient *insights.ThreatIntelligenceIndicatorClient
+	if !s.opts.UploadAPI {
+		var err error
+		if tiClient, err = s.newTIClient(); err != nil {
+			return err
+		}
+	}
+
+	today := time.Now()
+	pending := make([]PlanChange, 0)
+	existing := &existingIndicators{sentinel: s, tiClient: tiClient, source: source}
 
-		expirationDate, err := time.Parse("2006-01-02T15:04:05.999999999Z07:00", attribute.LastSeen.(string))
+	for page := range pages {
+		changes, err := s.planItems(ctx, logger, tiClient, existing, source, s.newItems(logger, page, today), today)
 		if err != nil {
-			expirationDate = defaultExpiration
-			attrLogger.WithError(err).WithField("raw", attribute.LastSeen.(string)).Error("could not parse attribute last_seen")
-		} else {
-			expirationDate = expirationDate.AddDate(0, int(expireMonths), 0)
+			return err
+		}
+
+		pending = append(pending, changes...)
+
+		flush := len(pending)
+		if s.opts.UploadAPI {
+			// keep what does not fill a batch for the next pages
+			flush -= len(pending) % uploadMaxBatchSize
+		}
+
+		if flush > 0 {
+			if err := handle(ctx, logger, pending[:flush]); err != nil {
+				return err
+			}
+
+			pending = append(make([]PlanChange, 0), pending[flush:]...)
+		}
+	}
+
+	return handle(ctx, logger, pending)
+}
+
+// planItems works out the change of every item, the existing ARM indicators are looked up on the worker pool.
+// Items without sync state are matched by external ID first, so a lost state file does not create duplicates.
+func (s *Sentinel) planItems(ctx context.Context, logger *logrus.Entry, tiClient *insights.ThreatIntelligenceIndicatorClient, existing *existingIndicators, source string, items []tiItem, today time.Time) ([]PlanChange, error) {
+	changes := make([]PlanChange, len(items))
+	planned := make([]bool, len(items))
+
+	pool := newWorkerPool(ctx, s.opts.Workers)
+
+	for i, item := range items {
+		i, item := i, item
+		itemLogger := logger.WithField("external_id", item.ExternalID).WithField("name", item.Name).
+			WithField("expires", item.ValidUntil.Format("2006-01-02"))
+
+		if item.ValidUntil.Before(today) {
+			itemLogger.Debug("skipping expired MISP item")
+			metrics.MISPAttributesFiltered.WithLabelValues(metrics.FilteredExpired).Inc()
+			continue
 		}
 
-		tsUnix, err := strconv.ParseInt(attribute.Timestamp, 10, 64)
+		record, err := s.lookupState(item.UUID)
 		if err != nil {
-			attrLogger.WithError(err).WithField("ts", attribute.Timestamp).
-				Error("could not parse attribute timestamp")
-			tsUnix = today.Unix()
+			pool.fail(err)
+			break
+		}
+
+		if record != nil && record.Hash == item.Hash {
+			itemLogger.Debug("skipping unchanged item")
+			changes[i], planned[i] = newChange(PlanUnchanged, item), true
+			changes[i].IndicatorName = record.IndicatorName
+			continue
+		}
+
+		if itemLogger.Logger.IsLevelEnabled(logrus.DebugLevel) && strings.EqualFold(item.ThreatType, "Other") {
+			itemLogger.WithField("type", item.IndicatorType).Debug("got indicator type Other")
 		}
 
-		timestamp := time.Unix(tsUnix, 0)
+		if s.opts.UploadAPI {
+			// the upload API creates or updates by STIX ID, there is nothing to look up
+			change := newChange(PlanCreate, item)
+			stix := newSTIXIndicator(item, createdTime(record))
+
+			if record != nil {
+				change.Action = PlanUpdate
+				change.IndicatorName = record.IndicatorName
+
+				// the modified time must increase for Sentinel to accept the new revision, also when only the confidence changed
+				stix.Modified = today.UTC().Format(time.RFC3339)
+			}
 
-		attrLogger = attrLogger.WithField("expires", expirationDate.Format("2006-01-02"))
+			change.STIX = &stix
 
-		if expirationDate.Before(today) {
-			attrLogger.WithField("last_seen", attribute.LastSeen.(string)).
-				WithField("last_seen_raw", attribute.LastSeen.(string)).
-				Debug("skipping expired MISP attribute")
+			changes[i], planned[i] = change, true
 			continue
 		}
 
-		attributeName := attribute.Category + ": " + attribute.Value
-		attrLogger = attrLogger.WithField("name", attributeName)
+		desired := newIndicatorProperties(item, source)
+
+		indicatorName := ""
+		if record != nil {
+			indicatorName = record.IndicatorName
+		}
 
-		if _, err := tiClient.Get(ctx, s.creds.ResourceGroup, s.creds.WorkspaceName, attributeName, nil); err != nil {
-			attrLogger.Info("skipping pre-existing attribute")
+		if indicatorName == "" {
+			if indicatorName, err = existing.lookup(ctx, item); err != nil {
+				pool.fail(err)
+				break
+			}
+
+			if indicatorName != "" {
+				itemLogger.WithField("name", indicatorName).Debug("found indicator without sync state")
+			}
+		}
+
+		if indicatorName == "" {
+			changes[i], planned[i] = newChange(PlanCreate, item), true
+			changes[i].Properties = desired
 			continue
 		}
 
-		threatType := getThreatType(attribute.Type)
-		if attrLogger.Logger.IsLevelEnabled(logrus.DebugLevel) && strings.EqualFold(threatType, "Other") {
-			attrLogger.WithField("type", attribute.Type).Debug("got attribute type Other")
-		}
-
-		if _, err = tiClient.CreateIndicator(ctx, s.creds.ResourceGroup, s.creds.WorkspaceName, insights.ThreatIntelligenceIndicatorModel{
-			Kind: nil,
-			Properties: &insights.ThreatIntelligenceIndicatorProperties{
-				Confidence:                 nil,
-				Created:                    to.Ptr[string](timestamp.Format(time.RFC3339)),
-				CreatedByRef:               to.Ptr[string](mispHostname),
-				Defanged:                   nil,
-				Description:                to.Ptr[string](attribute.Comment),
-				DisplayName:                to.Ptr[string](attributeName),
-				Extensions:                 nil,
-				ExternalID:                 to.Ptr[string](attribute.ID),
-				ExternalLastUpdatedTimeUTC: nil,
-				ExternalReferences:         nil,
-				GranularMarkings:           nil,
-				IndicatorTypes: []*string{
-					to.Ptr[string](attribute.Type),
-				},
-				KillChainPhases: nil,
-				Labels: []*string{
-					to.Ptr[string]("info:" + attribute.Event.Info),
-					to.Ptr[string]("category:" + attribute.Category),
-					to.Ptr[string]("type:" + attribute.Type),
-				},
-				Language:               nil,
-				LastUpdatedTimeUTC:     to.Ptr[string](timestamp.Format(time.RFC3339)),
-				Modified:               to.Ptr[string](timestamp.Format(time.RFC3339)),
-				ObjectMarkingRefs:      nil,
-				ParsedPattern:          nil,
-				Pattern:                to.Ptr[string](attribute.Value),
-				PatternType:            to.Ptr[string](attribute.Type),
-				PatternVersion:         nil,
-				Revoked:                to.Ptr[bool](attribute.Deleted),
-				Source:                 to.Ptr[string](mispHostname),
-				ThreatIntelligenceTags: nil,
-				ThreatTypes:            []*string{to.Ptr(threatType)},
-				ValidFrom:              to.Ptr[string](timestamp.Format(time.RFC3339)),
-				ValidUntil:             to.Ptr[string](expirationDate.Format(time.RFC3339)),
-			},
-		}, nil); err != nil {
-			attrLogger.WithError(err).Error("could not create attribute")
-			return fmt.Errorf("could not create attribute %s: %v", attribute.ID, err)
-		}
-
-		numCreated += 1
-
-		attrLogger.WithField("expires", expirationDate.Format("2006-01-02")).
-			Info("created attribute in Sentinel")
+		if !pool.submit(func(ctx context.Context) error {
+			current, err := s.getIndicator(ctx, tiClient, indicatorName)
+			if err != nil {
+				return err
+			}
+
+			change := newChange(PlanCreate, item)
+			change.Properties = desired
+
+			// only update if the indicator was not removed from Sentinel in the meantime
+			if current != nil && current.Properties != nil {
+				change.IndicatorName = indicatorName
+				change.Action = PlanUnchanged
+				change.Properties = nil
+
+				if changed := diffIndicator(current.Properties, desired); len(changed) > 0 {
+					applyIndicatorChanges(current.Properties, desired, changed)
+
+					change.Action = PlanUpdate
+					change.Changed = changed
+					change.Properties = current.Properties
+				}
+			}
+
+			changes[i], planned[i] = change, true
+			return nil
+		}) {
+			break
+		}
+	}
+
+	if err := pool.wait(); err != nil {
+		return nil, err
 	}
 
-	if numCreated > 0 {
-		logger.WithField("num", numCreated).Info("successfully pushed TI attributes into Sentinel")
+	result := make([]PlanChange, 0, len(items))
+	for i, change := range changes {
+		if planned[i] {
+			result = append(result, change)
+		}
 	}
 
-	return nil
+	return result, nil
 }
@@ -0,0 +1,207 @@
+package sentinel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/state"
+	"github.com/sirupsen/logrus"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeSource exports a fixed set of indicators, in pages of pageSize or in a single page.
+// The indicators in retracted were removed from the source.
+type fakeSource struct {
+	indicators []indicator.Indicator
+	pageSize   int
+	retracted  map[string]bool
+}
+
+func (f *fakeSource) Name() string {
+	return "MISP"
+}
+
+func (f *fakeSource) FetchIndicators(_ context.Context, since time.Time, fn func([]indicator.Indicator) error) error {
+	changed := make([]indicator.Indicator, 0, len(f.indicators))
+	for _, ind := range f.indicators {
+		if ind.Modified.After(since) {
+			changed = append(changed, ind)
+		}
+	}
+
+	return f.paginate(changed, fn)
+}
+
+func (f *fakeSource) FetchCurrent(_ context.Context, _ []indicator.Ref, fn func([]indicator.Indicator) error) error {
+	return f.paginate(f.indicators, fn)
+}
+
+func (f *fakeSource) FetchRetractions(_ context.Context, refs []indicator.Ref) (map[string]indicator.Retraction, error) {
+	retractions := make(map[string]indicator.Retraction)
+
+	for _, ref := range refs {
+		if f.retracted[ref.UUID] {
+			retractions[ref.UUID] = indicator.Retraction{Reason: indicator.RetractionRemoved}
+		}
+	}
+
+	return retractions, nil
+}
+
+func (f *fakeSource) paginate(indicators []indicator.Indicator, fn func([]indicator.Indicator) error) error {
+	pageSize := f.pageSize
+	if pageSize <= 0 {
+		pageSize = len(indicators) + 1
+	}
+
+	for start := 0; start < len(indicators); start += pageSize {
+		end := start + pageSize
+		if end > len(indicators) {
+			end = len(indicators)
+		}
+
+		if err := fn(indicators[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newTestIndicator(uuid, attributeType, value string, modified time.Time) indicator.Indicator {
+	return indicator.Indicator{
+		ID:          uuid[len(uuid)-2:],
+		UUID:        uuid,
+		Type:        attributeType,
+		Category:    "Network activity",
+		Observables: []indicator.Observable{{Type: attributeType, Value: value}},
+		Modified:    modified,
+		Group:       indicator.Group{ID: "1", UUID: "00000000-0000-0000-0000-0000000000e1", Info: "test event"},
+		Hash:        uuid + value,
+	}
+}
+
+func openTestStore(t *testing.T) *state.Store {
+	t.Helper()
+
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("could not open state: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	return store
+}
+
+func TestStreamSubmit(t *testing.T) {
+	modified := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+
+	indicators := make([]indicator.Indicator, 0, 150)
+	for i := 0; i < cap(indicators); i++ {
+		uuid := fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+		indicators = append(indicators, newTestIndicator(uuid, "ip-dst", fmt.Sprintf("10.0.%d.%d", i/256, i%256), modified.Add(time.Duration(i)*time.Second)))
+	}
+
+	tests := []struct {
+		name        string
+		uploadAPI   bool
+		wantUploads int
+	}{
+		// every page is pushed as it arrives
+		{name: "arm api"},
+		// pages are collected into full batches
+		{name: "upload api", uploadAPI: true, wantUploads: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			store := openTestStore(t)
+			fc := newFakeCloud(t)
+
+			s, _ := newTestSentinel(t, fc, store, Options{UploadAPI: tt.uploadAPI, ExpireMonths: 6, Workers: 4})
+			source := &fakeSource{indicators: indicators, pageSize: 40}
+
+			latest, err := indicator.Stream(context.Background(), logger, source, s, time.Time{})
+			if err != nil {
+				t.Fatalf("Stream() error = %v", err)
+			}
+
+			if want := indicators[len(indicators)-1].Modified; !latest.Equal(want) {
+				t.Errorf("Stream() = %s, want %s", latest, want)
+			}
+
+			if len(fc.created) != len(indicators) {
+				t.Errorf("created %d indicators in Sentinel, want %d", len(fc.created), len(indicators))
+			}
+
+			if len(fc.uploads) != tt.wantUploads {
+				t.Errorf("sent %d upload requests, want %d", len(fc.uploads), tt.wantUploads)
+			}
+
+			saved := 0
+			if err := store.Walk(func(state.Record) error {
+				saved++
+				return nil
+			}); err != nil {
+				t.Fatalf("could not walk state: %v", err)
+			}
+
+			if saved != len(indicators) {
+				t.Errorf("saved the sync state of %d indicators, want %d", saved, len(indicators))
+			}
+		})
+	}
+}
+
+func TestUploadKeepsCreated(t *testing.T) {
+	ctx := context.Background()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	modified := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+	domain := newTestIndicator("00000000-0000-0000-0000-0000000000a1", "domain", "example.com", modified)
+
+	store := openTestStore(t)
+	fc := newFakeCloud(t)
+	s, _ := newTestSentinel(t, fc, store, Options{UploadAPI: true, ExpireMonths: 6, Workers: 2})
+
+	source := &fakeSource{indicators: []indicator.Indicator{domain}}
+
+	// every revision is uploaded with the created time of the first one
+	for revision := 0; revision < 3; revision++ {
+		if revision > 0 {
+			source.indicators[0].Description = fmt.Sprintf("revision %d", revision)
+			source.indicators[0].Hash += "changed"
+			source.indicators[0].Modified = modified.Add(time.Duration(revision) * time.Hour)
+		}
+
+		if _, err := indicator.Stream(ctx, logger, source, s, time.Time{}); err != nil {
+			t.Fatalf("could not sync revision %d: %v", revision, err)
+		}
+
+		var properties struct {
+			Created     string `json:"created"`
+			Description string `json:"description"`
+		}
+		if err := json.Unmarshal(fc.indicators[fc.uploadedName(stixIndicatorPrefix+domain.UUID)], &properties); err != nil {
+			t.Fatalf("could not decode uploaded indicator: %v", err)
+		}
+
+		if want := modified.Format(time.RFC3339); properties.Created != want || properties.Description != source.indicators[0].Description {
+			t.Errorf("revision %d was uploaded as %+v, want created %s", revision, properties, want)
+		}
+
+		record, err := store.Get(domain.UUID)
+		if err != nil || record == nil || !record.Created.Equal(modified) {
+			t.Errorf("sync state of revision %d is %+v, want created %s", revision, record, modified)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package sentinel
+
+import (
+	"context"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/metrics"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// the request rate never drops below this amount of requests per second
+	throttleMinRate = 0.2
+	// the rate grows by this fraction of the maximum rate after every successful request
+	throttleIncrease = 0.05
+	// below this remaining ARM quota the rate is lowered before Azure starts rejecting requests
+	throttleLowRemaining = 50
+
+	rateLimitRemainingPrefix = "X-Ms-Ratelimit-Remaining-"
+)
+
+// throttle is a pipeline policy that paces the requests of all clients, it slows down when Azure throttles
+// or reports a low remaining quota and speeds back up to the maximum rate while requests succeed.
+type throttle struct {
+	mu sync.Mutex
+	// maxRate is in requests per second, zero does not limit the rate but still honours Retry-After
+	maxRate float64
+	rate    float64
+	// next is the earliest time the next request may be sent
+	next time.Time
+}
+
+func newThrottle(maxRate float64) *throttle {
+	if maxRate < 0 {
+		maxRate = 0
+	}
+
+	return &throttle{
+		maxRate: maxRate,
+		rate:    math.Max(maxRate/4, throttleMinRate),
+	}
+}
+
+// Do waits for a free slot, sends the request and adjusts the rate to the response.
+func (t *throttle) Do(req *policy.Request) (*http.Response, error) {
+	if err := t.wait(req.Raw().Context()); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := req.Next()
+	observeRequest(req.Raw().Method, resp, start)
+
+	if resp != nil {
+		t.observe(resp)
+	}
+
+	return resp, err
+}
+
+func (t *throttle) wait(ctx context.Context) error {
+	t.mu.Lock()
+
+	slot := t.next
+	if now := time.Now(); slot.Before(now) {
+		slot = now
+	}
+
+	if t.maxRate > 0 {
+		t.next = slot.Add(time.Duration(float64(time.Second) / t.rate))
+	} else {
+		t.next = slot
+	}
+
+	t.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (t *throttle) observe(resp *http.Response) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		t.rate = math.Max(t.rate/2, throttleMinRate)
+		metrics.AzureThrottleEvents.WithLabelValues(metrics.ThrottleRejected).Inc()
+
+		// hold back every request until Azure accepts them again
+		if pause := time.Now().Add(retryAfter(resp.Header)); pause.After(t.next) {
+			t.next = pause
+		}
+
+		return
+	}
+
+	if remaining, ok := remainingQuota(resp.Header); ok && remaining < throttleLowRemaining {
+		t.rate = math.Max(t.rate*0.75, throttleMinRate)
+		metrics.AzureThrottleEvents.WithLabelValues(metrics.ThrottleLowQuota).Inc()
+		return
+	}
+
+	if resp.StatusCode < 400 && t.maxRate > 0 {
+		t.rate = math.Min(t.rate+t.maxRate*throttleIncrease, t.maxRate)
+	}
+}
+
+// retryAfter returns how long Azure asked to wait, in order of the headers azcore itself honours.
+func retryAfter(header http.Header) time.Duration {
+	for _, name := range []string{"Retry-After-Ms", "X-Ms-Retry-After-Ms"} {
+		if ms, err := strconv.Atoi(header.Get(name)); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+
+	return 0
+}
+
+// remainingQuota returns the lowest of the x-ms-ratelimit-remaining-* quotas in the response.
+func remainingQuota(header http.Header) (int, bool) {
+	lowest := 0
+	found := false
+
+	for name, values := range header {
+		if !strings.HasPrefix(http.CanonicalHeaderKey(name), rateLimitRemainingPrefix) || len(values) == 0 {
+			continue
+		}
+
+		remaining, err := strconv.Atoi(values[0])
+		if err != nil {
+			continue
+		}
+
+		if !found || remaining < lowest {
+			lowest = remaining
+			found = true
+		}
+	}
+
+	return lowest, found
+}
@@ -0,0 +1,147 @@
+package sentinel
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// https://learn.microsoft.com/en-us/azure/sentinel/upload-indicators-api
+	uploadAPIVersion = "2022-07-01"
+
+	// the upload API accepts at most this amount of indicators per request
+	uploadMaxBatchSize = 100
+)
+
+type stixIndicator struct {
+	Type           string   `json:"type"`
+	SpecVersion    string   `json:"spec_version"`
+	ID             string   `json:"id"`
+	Created        string   `json:"created"`
+	Modified       string   `json:"modified"`
+	Revoked        bool     `json:"revoked"`
+	Labels         []string `json:"labels,omitempty"`
+	Name           string   `json:"name"`
+	Confidence     int      `json:"confidence"`
+	Description    string   `json:"description,omitempty"`
+	IndicatorTypes []string `json:"indicator_types,omitempty"`
+	Pattern        string   `json:"pattern"`
+	PatternType    string   `json:"pattern_type"`
+	PatternVersion string   `json:"pattern_version,omitempty"`
+	ValidFrom      string   `json:"valid_from"`
+	ValidUntil     string   `json:"valid_until,omitempty"`
+
+	KillChainPhases    []killChainPhase    `json:"kill_chain_phases,omitempty"`
+	ExternalReferences []externalReference `json:"external_references,omitempty"`
+	ObjectMarkingRefs  []string            `json:"object_marking_refs,omitempty"`
+}
+
+type uploadRequest struct {
+	SourceSystem string          `json:"sourcesystem"`
+	Indicators   []stixIndicator `json:"indicators"`
+}
+
+type uploadResponse struct {
+	Errors []struct {
+		RecordIndex   int      `json:"recordIndex"`
+		ErrorMessages []string `json:"errorMessages"`
+	} `json:"errors"`
+}
+
+// newSTIXIndicator converts an item into a STIX 2.1 indicator object. created is when the indicator was first uploaded,
+// STIX does not allow a new revision to change it. Zero creates the indicator as of its modification time.
+func newSTIXIndicator(item tiItem, created time.Time) stixIndicator {
+	if created.IsZero() {
+		created = item.Modified
+	}
+
+	return stixIndicator{
+		Type:        "indicator",
+		SpecVersion: "2.1",
+		// reuse the MISP UUID so re-uploads update the same indicator
+		ID:             stixIndicatorPrefix + item.UUID,
+		Created:        created.UTC().Format(time.RFC3339),
+		Modified:       item.Modified.UTC().Format(time.RFC3339),
+		Revoked:        item.Revoked,
+		Labels:         item.Labels,
+		Name:           item.Name,
+		Confidence:     item.Confidence,
+		Description:    item.Description,
+		IndicatorTypes: []string{item.IndicatorType},
+		Pattern:        item.Pattern.String(),
+		PatternType:    stixPatternType,
+		PatternVersion: stixPatternVersion,
+		ValidFrom:      item.Modified.UTC().Format(time.RFC3339),
+		ValidUntil:     item.ValidUntil.UTC().Format(time.RFC3339),
+
+		KillChainPhases:    item.KillChain,
+		ExternalReferences: item.References,
+		ObjectMarkingRefs:  item.MarkingRefs,
+	}
+}
+
+// newUploadPipeline returns an authenticated pipeline and the endpoint for the upload API.
+func (s *Sentinel) newUploadPipeline() (runtime.Pipeline, string, error) {
+	if s.creds.WorkspaceID == "" {
+		return runtime.Pipeline{}, "", fmt.Errorf("no workspace ID provided for the upload API")
+	}
+
+	if s.cloud.uploadEndpoint == "" {
+		return runtime.Pipeline{}, "", fmt.Errorf("no upload API endpoint known for this cloud, configure it explicitly")
+	}
+
+	// the upload API accepts tokens for the resource manager
+	uploadScope := s.cloud.armEndpoint + "/.default"
+
+	clientOptions := s.clientOptions()
+	pipeline := runtime.NewPipeline("mispsent", "v1", runtime.PipelineOptions{
+		PerRetry: []policy.Policy{s.throttle, runtime.NewBearerTokenPolicy(s.cred, []string{uploadScope}, nil)},
+	}, &clientOptions)
+
+	uploadURL := fmt.Sprintf("%s/%s/threatintelligence:upload-indicators?api-version=%s",
+		s.cloud.uploadEndpoint, url.PathEscape(s.creds.WorkspaceID), uploadAPIVersion)
+
+	return pipeline, uploadURL, nil
+}
+
+// uploadBatch uploads a single batch and returns the error messages per rejected indicator index.
+func uploadBatch(ctx context.Context, pipeline runtime.Pipeline, uploadURL, sourceSystem string, batch []stixIndicator) (map[int][]string, error) {
+	req, err := runtime.NewRequest(ctx, http.MethodPost, uploadURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not create upload request: %v", err)
+	}
+
+	if err := runtime.MarshalAsJSON(req, uploadRequest{SourceSystem: sourceSystem, Indicators: batch}); err != nil {
+		return nil, fmt.Errorf("could not encode upload request: %v", err)
+	}
+
+	resp, err := pipeline.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not upload indicators: %v", err)
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return nil, fmt.Errorf("could not upload indicators: %v", runtime.NewResponseError(resp))
+	}
+
+	var response uploadResponse
+	if err := runtime.UnmarshalAsJSON(resp, &response); err != nil {
+		return nil, fmt.Errorf("could not decode upload response: %v", err)
+	}
+
+	failed := make(map[int][]string, len(response.Errors))
+	for _, uploadErr := range response.Errors {
+		if uploadErr.RecordIndex < 0 || uploadErr.RecordIndex >= len(batch) {
+			continue
+		}
+
+		failed[uploadErr.RecordIndex] = uploadErr.ErrorMessages
+	}
+
+	return failed, nil
+}
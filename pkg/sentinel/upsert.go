@@ -0,0 +1,256 @@
+package sentinel
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/securityinsights/armsecurityinsights/v2"
+	"sort"
+	"strings"
+	"time"
+)
+
+// indicatorField describes a property that is synced from MISP onto an existing indicator.
+type indicatorField struct {
+	name  string
+	equal func(current, desired *insights.ThreatIntelligenceIndicatorProperties) bool
+	apply func(current, desired *insights.ThreatIntelligenceIndicatorProperties)
+}
+
+var indicatorFields = []indicatorField{
+	{
+		name: "DisplayName",
+		equal: func(c, d *insights.ThreatIntelligenceIndicatorProperties) bool {
+			return equalString(c.DisplayName, d.DisplayName)
+		},
+		apply: func(c, d *insights.ThreatIntelligenceIndicatorProperties) { c.DisplayName = d.DisplayName },
+	},
+	{
+		name: "Description",
+		equal: func(c, d *insights.ThreatIntelligenceIndicatorProperties) bool {
+			return equalString(c.Description, d.Description)
+		},
+		apply: func(c, d *insights.ThreatIntelligenceIndicatorProperties) { c.Description = d.Description },
+	},
+	{
+		name: "Pattern",
+		equal: func(c, d *insights.ThreatIntelligenceIndicatorProperties) bool {
+			return equalString(c.Pattern, d.Pattern) && equalString(c.PatternType, d.PatternType)
+		},
+		apply: func(c, d *insights.ThreatIntelligenceIndicatorProperties) {
+			c.Pattern = d.Pattern
+			c.PatternType = d.PatternType
+			c.ParsedPattern = d.ParsedPattern
+		},
+	},
+	{
+		name: "ValidUntil",
+		equal: func(c, d *insights.ThreatIntelligenceIndicatorProperties) bool {
+			return equalTime(c.ValidUntil, d.ValidUntil)
+		},
+		apply: func(c, d *insights.ThreatIntelligenceIndicatorProperties) { c.ValidUntil = d.ValidUntil },
+	},
+	{
+		name: "Labels",
+		equal: func(c, d *insights.ThreatIntelligenceIndicatorProperties) bool {
+			return equalStrings(c.Labels, d.Labels)
+		},
+		apply: func(c, d *insights.ThreatIntelligenceIndicatorProperties) { c.Labels = d.Labels },
+	},
+	{
+		name: "Confidence",
+		equal: func(c, d *insights.ThreatIntelligenceIndicatorProperties) bool {
+			return equalInt32(c.Confidence, d.Confidence)
+		},
+		apply: func(c, d *insights.ThreatIntelligenceIndicatorProperties) { c.Confidence = d.Confidence },
+	},
+	{
+		name: "ObjectMarkingRefs",
+		equal: func(c, d *insights.ThreatIntelligenceIndicatorProperties) bool {
+			return equalStrings(c.ObjectMarkingRefs, d.ObjectMarkingRefs)
+		},
+		apply: func(c, d *insights.ThreatIntelligenceIndicatorProperties) { c.ObjectMarkingRefs = d.ObjectMarkingRefs },
+	},
+	{
+		name: "KillChainPhases",
+		equal: func(c, d *insights.ThreatIntelligenceIndicatorProperties) bool {
+			return equalKillChainPhases(c.KillChainPhases, d.KillChainPhases)
+		},
+		apply: func(c, d *insights.ThreatIntelligenceIndicatorProperties) { c.KillChainPhases = d.KillChainPhases },
+	},
+	{
+		name: "ExternalReferences",
+		equal: func(c, d *insights.ThreatIntelligenceIndicatorProperties) bool {
+			return equalExternalReferences(c.ExternalReferences, d.ExternalReferences)
+		},
+		apply: func(c, d *insights.ThreatIntelligenceIndicatorProperties) {
+			c.ExternalReferences = d.ExternalReferences
+		},
+	},
+	{
+		name: "IndicatorTypes",
+		equal: func(c, d *insights.ThreatIntelligenceIndicatorProperties) bool {
+			return equalStrings(c.IndicatorTypes, d.IndicatorTypes)
+		},
+		apply: func(c, d *insights.ThreatIntelligenceIndicatorProperties) { c.IndicatorTypes = d.IndicatorTypes },
+	},
+	{
+		name: "ThreatTypes",
+		equal: func(c, d *insights.ThreatIntelligenceIndicatorProperties) bool {
+			return equalStrings(c.ThreatTypes, d.ThreatTypes)
+		},
+		apply: func(c, d *insights.ThreatIntelligenceIndicatorProperties) { c.ThreatTypes = d.ThreatTypes },
+	},
+	{
+		name: "Revoked",
+		equal: func(c, d *insights.ThreatIntelligenceIndicatorProperties) bool {
+			return equalBool(c.Revoked, d.Revoked)
+		},
+		apply: func(c, d *insights.ThreatIntelligenceIndicatorProperties) { c.Revoked = d.Revoked },
+	},
+}
+
+// diffIndicator returns the names of the synced properties that differ from the desired state.
+func diffIndicator(current, desired *insights.ThreatIntelligenceIndicatorProperties) []string {
+	changed := make([]string, 0)
+
+	for _, field := range indicatorFields {
+		if !field.equal(current, desired) {
+			changed = append(changed, field.name)
+		}
+	}
+
+	return changed
+}
+
+// applyIndicatorChanges copies the changed properties onto the current indicator properties.
+func applyIndicatorChanges(current, desired *insights.ThreatIntelligenceIndicatorProperties, changed []string) {
+	for _, name := range changed {
+		for _, field := range indicatorFields {
+			if field.name == name {
+				field.apply(current, desired)
+			}
+		}
+	}
+
+	current.Modified = desired.Modified
+	current.LastUpdatedTimeUTC = desired.LastUpdatedTimeUTC
+}
+
+// getIndicator fetches an indicator by name, returning nil if it does not exist.
+func (s *Sentinel) getIndicator(ctx context.Context, tiClient *insights.ThreatIntelligenceIndicatorClient, name string) (*insights.ThreatIntelligenceIndicatorModel, error) {
+	resp, err := tiClient.Get(ctx, s.creds.ResourceGroup, s.creds.WorkspaceName, name, nil)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("could not get indicator '%s': %v", name, err)
+	}
+
+	indicator, ok := resp.ThreatIntelligenceInformationClassification.(*insights.ThreatIntelligenceIndicatorModel)
+	if !ok {
+		return nil, fmt.Errorf("indicator '%s' is not a TI indicator", name)
+	}
+
+	return indicator, nil
+}
+
+func equalString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b || (a == nil && *b == "") || (b == nil && *a == "")
+	}
+
+	return *a == *b
+}
+
+func equalBool(a, b *bool) bool {
+	return (a != nil && *a) == (b != nil && *b)
+}
+
+func equalInt32(a, b *int32) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+func equalKillChainPhases(a, b []*insights.ThreatIntelligenceKillChainPhase) bool {
+	flatten := func(phases []*insights.ThreatIntelligenceKillChainPhase) []*string {
+		flat := make([]*string, 0, len(phases))
+		for _, phase := range phases {
+			if phase != nil {
+				flat = append(flat, to.Ptr(stringValue(phase.KillChainName)+":"+stringValue(phase.PhaseName)))
+			}
+		}
+		return flat
+	}
+
+	return equalStrings(flatten(a), flatten(b))
+}
+
+func equalExternalReferences(a, b []*insights.ThreatIntelligenceExternalReference) bool {
+	flatten := func(references []*insights.ThreatIntelligenceExternalReference) []*string {
+		flat := make([]*string, 0, len(references))
+		for _, reference := range references {
+			if reference != nil {
+				flat = append(flat, to.Ptr(strings.Join([]string{
+					stringValue(reference.SourceName), stringValue(reference.Description),
+					stringValue(reference.URL), stringValue(reference.ExternalID),
+				}, "|")))
+			}
+		}
+		return flat
+	}
+
+	return equalStrings(flatten(a), flatten(b))
+}
+
+// equalTime compares timestamps semantically since Sentinel returns them in its own format.
+func equalTime(a, b *string) bool {
+	if a == nil || b == nil {
+		return equalString(a, b)
+	}
+
+	timeA, errA := time.Parse(time.RFC3339, *a)
+	timeB, errB := time.Parse(time.RFC3339, *b)
+	if errA != nil || errB != nil {
+		return *a == *b
+	}
+
+	return timeA.Equal(timeB)
+}
+
+// equalStrings compares two string lists regardless of their order.
+func equalStrings(a, b []*string) bool {
+	sortedA := derefStrings(a)
+	sortedB := derefStrings(b)
+
+	if len(sortedA) != len(sortedB) {
+		return false
+	}
+
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func derefStrings(values []*string) []string {
+	result := make([]string, 0, len(values))
+
+	for _, value := range values {
+		if value != nil {
+			result = append(result, *value)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,92 @@
+package sentinel
+
+import (
+	"context"
+	"github.com/hazcod/crowdstrike2sentinel/pkg/indicator"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateIndicator(t *testing.T) {
+	modified := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+
+	domain := newTestIndicator("00000000-0000-0000-0000-0000000000a1", "domain", "example.com", modified)
+
+	changed := domain
+	changed.Description = "changed"
+	changed.Hash += "changed"
+	changed.Modified = modified.Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		indicator   indicator.Indicator
+		wantUpdates int
+	}{
+		{name: "modified", indicator: changed, wantUpdates: 1},
+		{name: "unchanged", indicator: domain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			store := openTestStore(t)
+			fc := newFakeCloud(t)
+			s, _ := newTestSentinel(t, fc, store, Options{ExpireMonths: 6, Workers: 2})
+
+			source := &fakeSource{indicators: []indicator.Indicator{domain}}
+			if _, err := indicator.Stream(ctx, logger, source, s, time.Time{}); err != nil {
+				t.Fatalf("could not sync: %v", err)
+			}
+
+			before, err := store.Get(domain.UUID)
+			if err != nil || before == nil {
+				t.Fatalf("no sync state after the first sync: %v", err)
+			}
+
+			requests := len(fc.armRequests)
+			source.indicators = []indicator.Indicator{tt.indicator}
+
+			if _, err := indicator.Stream(ctx, logger, source, s, modified.Add(-time.Second)); err != nil {
+				t.Fatalf("could not sync again: %v", err)
+			}
+
+			updates := 0
+			for _, request := range fc.armRequests[requests:] {
+				if strings.HasPrefix(request, "PUT ") && strings.HasSuffix(request, "/indicators/"+before.IndicatorName) {
+					updates++
+				} else if strings.HasSuffix(request, "/createIndicator") {
+					t.Errorf("sync created the indicator again: %s", request)
+				}
+			}
+
+			if updates != tt.wantUpdates {
+				t.Errorf("sent %d updates, want %d", updates, tt.wantUpdates)
+			}
+
+			if len(fc.created) != 1 {
+				t.Errorf("Sentinel has %d indicators, want 1", len(fc.created))
+			}
+
+			after, err := store.Get(domain.UUID)
+			if err != nil || after == nil {
+				t.Fatalf("no sync state after the second sync: %v", err)
+			}
+
+			// the sync state keeps the indicator name and only takes the new hash when the indicator was updated
+			if after.IndicatorName != before.IndicatorName || after.Revoked || (after.Hash != before.Hash) != (tt.wantUpdates > 0) {
+				t.Errorf("sync state is %+v after the update, was %+v", after, before)
+			}
+
+			if properties := string(fc.indicators[before.IndicatorName]); !strings.Contains(properties, tt.indicator.Description) {
+				t.Errorf("indicator has properties %s, want description %q", properties, tt.indicator.Description)
+			}
+		})
+	}
+}
@@ -5,24 +5,18 @@ import (
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights/v2"
 	"github.com/hazcod/crowdstrike2sentinel/pkg/vuln"
 	"github.com/sirupsen/logrus"
 	"time"
 )
 
-func CreateTable(ctx context.Context, l *logrus.Logger, retentionDays uint32, creds Credentials) error {
+func (s *Sentinel) CreateTable(ctx context.Context, l *logrus.Logger, retentionDays uint32) error {
 	logger := l.WithField("module", "sentinel_vuln")
 
-	cred, err := azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, creds.ClientSecret, nil)
-	if err != nil {
-		return fmt.Errorf("could not authenticate to MS Sentinel: %v", err)
-	}
-
 	logger.WithField("table_name", vuln.TableNameVulnerabilities).Info("creating table")
 
-	tablesClient, err := insights.NewTablesClient(creds.SubscriptionID, cred, nil)
+	tablesClient, err := insights.NewTablesClient(s.creds.SubscriptionID, s.cred, s.armOptions())
 	if err != nil {
 		return fmt.Errorf("could not create ms graph table client: %v", err)
 	}
@@ -30,7 +24,7 @@ func CreateTable(ctx context.Context, l *logrus.Logger, retentionDays uint32, cr
 	retention := int32(retentionDays)
 
 	poller, err := tablesClient.BeginCreateOrUpdate(ctx,
-		creds.ResourceGroup, creds.WorkspaceName, vuln.TableNameVulnerabilities,
+		s.creds.ResourceGroup, s.creds.WorkspaceName, vuln.TableNameVulnerabilities,
 		insights.Table{
 			Properties: &insights.TableProperties{
 				RetentionInDays:      &retention,
@@ -0,0 +1,84 @@
+package sentinel
+
+import (
+	"context"
+	"sync"
+)
+
+// workerPool runs jobs on a fixed amount of goroutines, submitting blocks while every worker is busy.
+// The first failing job stops the pool, jobs that were not started yet are skipped.
+type workerPool struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   chan func(context.Context) error
+	wg     sync.WaitGroup
+
+	once sync.Once
+	err  error
+}
+
+func newWorkerPool(ctx context.Context, workers int) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	poolCtx, cancel := context.WithCancel(ctx)
+
+	pool := &workerPool{
+		parent: ctx,
+		ctx:    poolCtx,
+		cancel: cancel,
+		jobs:   make(chan func(context.Context) error),
+	}
+
+	for i := 0; i < workers; i++ {
+		pool.wg.Add(1)
+
+		go func() {
+			defer pool.wg.Done()
+
+			for job := range pool.jobs {
+				if pool.ctx.Err() != nil {
+					continue
+				}
+
+				if err := job(pool.ctx); err != nil {
+					pool.fail(err)
+				}
+			}
+		}()
+	}
+
+	return pool
+}
+
+// submit queues a job, it returns false once the pool stopped and no more jobs should be submitted.
+func (p *workerPool) submit(job func(context.Context) error) bool {
+	select {
+	case p.jobs <- job:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// wait waits for the submitted jobs to finish and returns the first error.
+func (p *workerPool) wait() error {
+	close(p.jobs)
+	p.wg.Wait()
+	p.cancel()
+
+	if p.err != nil {
+		return p.err
+	}
+
+	return p.parent.Err()
+}
+
+func (p *workerPool) fail(err error) {
+	p.once.Do(func() {
+		p.err = err
+		p.cancel()
+	})
+}
@@ -0,0 +1,251 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+const (
+	// how long to wait for another process to release the state file
+	openTimeout = time.Second * 10
+)
+
+var (
+	bucketIndicators = []byte("indicators")
+	bucketMeta       = []byte("meta")
+
+	keyHighWaterMark = []byte("high_water_mark")
+	keyLastSync      = []byte("last_sync")
+	keyLastRecheck   = []byte("last_recheck")
+)
+
+// Record links a MISP attribute, or a whole MISP object, to the Sentinel indicator it was pushed as.
+type Record struct {
+	// AttributeUUID holds the object UUID for object records
+	AttributeUUID string    `json:"attribute_uuid"`
+	EventUUID     string    `json:"event_uuid"`
+	Object        bool      `json:"object,omitempty"`
+	IndicatorName string    `json:"indicator_name"`
+	Hash          string    `json:"hash"`
+	LastPushed    time.Time `json:"last_pushed"`
+	Revoked       bool      `json:"revoked,omitempty"`
+	// Created is when the indicator was first uploaded, every later revision keeps it
+	Created time.Time `json:"created,omitempty"`
+}
+
+type Store struct {
+	db *bolt.DB
+}
+
+func Open(path string) (*Store, error) {
+	if path == "" {
+		return nil, errors.New("no state path provided")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("could not open state file '%s': %v", path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketIndicators, bucketMeta} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("could not initialize state file '%s': %v", path, err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Get returns the record for the given attribute UUID, or nil if it was never pushed.
+func (s *Store) Get(attributeUUID string) (*Record, error) {
+	var record *Record
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(bucketIndicators).Get([]byte(attributeUUID))
+		if raw == nil {
+			return nil
+		}
+
+		record = &Record{}
+		return json.Unmarshal(raw, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read state for '%s': %v", attributeUUID, err)
+	}
+
+	return record, nil
+}
+
+func (s *Store) Put(record Record) error {
+	if record.AttributeUUID == "" {
+		return errors.New("record has no attribute UUID")
+	}
+
+	raw, err := json.Marshal(&record)
+	if err != nil {
+		return fmt.Errorf("could not encode state record: %v", err)
+	}
+
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketIndicators).Put([]byte(record.AttributeUUID), raw)
+	}); err != nil {
+		return fmt.Errorf("could not write state for '%s': %v", record.AttributeUUID, err)
+	}
+
+	return nil
+}
+
+func (s *Store) Delete(attributeUUID string) error {
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketIndicators).Delete([]byte(attributeUUID))
+	}); err != nil {
+		return fmt.Errorf("could not delete state for '%s': %v", attributeUUID, err)
+	}
+
+	return nil
+}
+
+// Prune deletes the records that were last pushed before the given time, and returns how many were deleted.
+func (s *Store) Prune(before time.Time) (int, error) {
+	pruned := 0
+
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketIndicators)
+
+		// collect first, deleting while iterating skips records
+		keys := make([][]byte, 0)
+		if err := bucket.ForEach(func(key, raw []byte) error {
+			var record Record
+			if err := json.Unmarshal(raw, &record); err != nil {
+				return fmt.Errorf("could not decode state for '%s': %v", string(key), err)
+			}
+
+			if record.LastPushed.Before(before) {
+				keys = append(keys, key)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		pruned = len(keys)
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("could not prune state: %v", err)
+	}
+
+	return pruned, nil
+}
+
+// Walk calls fn for every stored record, ordered by attribute UUID.
+func (s *Store) Walk(fn func(Record) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketIndicators).ForEach(func(key, raw []byte) error {
+			var record Record
+			if err := json.Unmarshal(raw, &record); err != nil {
+				return fmt.Errorf("could not decode state for '%s': %v", string(key), err)
+			}
+
+			return fn(record)
+		})
+	})
+}
+
+// HighWaterMark returns the most recent MISP modification time that was successfully pushed, or zero if there is none yet.
+func (s *Store) HighWaterMark() (time.Time, error) {
+	mark, err := s.readTime(keyHighWaterMark)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not read high-water mark: %v", err)
+	}
+
+	return mark, nil
+}
+
+func (s *Store) SetHighWaterMark(mark time.Time) error {
+	if err := s.writeTime(keyHighWaterMark, mark); err != nil {
+		return fmt.Errorf("could not write high-water mark: %v", err)
+	}
+
+	return nil
+}
+
+// LastSync returns when the last successful sync finished, or zero if there is none yet.
+func (s *Store) LastSync() (time.Time, error) {
+	last, err := s.readTime(keyLastSync)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not read last sync time: %v", err)
+	}
+
+	return last, nil
+}
+
+func (s *Store) SetLastSync(last time.Time) error {
+	if err := s.writeTime(keyLastSync, last); err != nil {
+		return fmt.Errorf("could not write last sync time: %v", err)
+	}
+
+	return nil
+}
+
+// LastRecheck returns when the pushed indicators were last checked against the source, or zero if they never were.
+func (s *Store) LastRecheck() (time.Time, error) {
+	last, err := s.readTime(keyLastRecheck)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not read last recheck time: %v", err)
+	}
+
+	return last, nil
+}
+
+func (s *Store) SetLastRecheck(last time.Time) error {
+	if err := s.writeTime(keyLastRecheck, last); err != nil {
+		return fmt.Errorf("could not write last recheck time: %v", err)
+	}
+
+	return nil
+}
+
+func (s *Store) readTime(key []byte) (time.Time, error) {
+	var value time.Time
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(bucketMeta).Get(key)
+		if raw == nil {
+			return nil
+		}
+
+		return value.UnmarshalText(raw)
+	})
+
+	return value, err
+}
+
+func (s *Store) writeTime(key []byte, value time.Time) error {
+	raw, err := value.UTC().MarshalText()
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMeta).Put(key, raw)
+	})
+}